@@ -0,0 +1,295 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// AdminRole is the role a user needs to use the AdminHandler
+	AdminRole = "admin"
+	// EventSystemMessage is a announcement sent by the operators of the server
+	EventSystemMessage = "system_message"
+)
+
+// maxCloseReason is the longest reason that fits in a close frame next to the code
+const maxCloseReason = 123
+
+var (
+	// ErrUnknownClient is returned by Kick when no client has the connection id
+	ErrUnknownClient = errors.New("no client with that connection id")
+	// ErrCloseReasonTooLong is returned by Kick when the reason does not fit in a close frame
+	ErrCloseReasonTooLong = errors.New("close reason can be at most 123 bytes")
+)
+
+// SystemMessageEvent is the payload of EventSystemMessage
+type SystemMessageEvent struct {
+	Message string    `json:"message"`
+	Sent    time.Time `json:"sent"`
+}
+
+// ClientInfo describes a connected client
+type ClientInfo struct {
+	ID             string    `json:"id"`
+	User           string    `json:"user"`
+	Room           string    `json:"room"`
+	RemoteAddr     string    `json:"remote_addr"`
+	ConnectedSince time.Time `json:"connected_since"`
+	// QueueDepth is how many events are waiting to be written to the client
+	QueueDepth int    `json:"queue_depth"`
+	Codec      string `json:"codec"`
+	// Compression is how much the messages written to the client has been compressed
+	Compression CompressionStats `json:"compression"`
+}
+
+// RoomInfo describes a room and the users in it
+type RoomInfo struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// Clients returns the connected clients, the longest connected first
+func (m *Manager) Clients() []ClientInfo {
+	m.RLock()
+	clients := make([]ClientInfo, 0, len(m.clients))
+	for client := range m.clients {
+		clients = append(clients, ClientInfo{
+			ID:             client.id,
+			User:           client.identity.Username,
+			Room:           client.Room(),
+			RemoteAddr:     client.remoteAddr,
+			ConnectedSince: client.created,
+			QueueDepth:     len(client.egress),
+			Codec:          client.codec.Subprotocol(),
+			Compression:    client.CompressionStats(),
+		})
+	}
+	m.RUnlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		if !clients[i].ConnectedSince.Equal(clients[j].ConnectedSince) {
+			return clients[i].ConnectedSince.Before(clients[j].ConnectedSince)
+		}
+		return clients[i].ID < clients[j].ID
+	})
+	return clients
+}
+
+// Rooms returns the rooms that has members and the users in them
+func (m *Manager) Rooms() []RoomInfo {
+	names := m.rooms.Rooms()
+	rooms := make([]RoomInfo, 0, len(names))
+	for _, name := range names {
+		rooms = append(rooms, RoomInfo{Name: name, Members: m.rooms.Usernames(name)})
+	}
+	return rooms
+}
+
+// Kick closes the connection of the client with code 1008 and reason. Its session ends with it,
+// so the client can not resume and has to log in again. The writer of the client sends the close frame
+// and removes it with removeClient
+func (m *Manager) Kick(id, reason string) error {
+	if len(reason) > maxCloseReason {
+		return ErrCloseReasonTooLong
+	}
+
+	var kicked *Client
+	m.RLock()
+	for client := range m.clients {
+		if client.id == id {
+			kicked = client
+			break
+		}
+	}
+	m.RUnlock()
+	if kicked == nil {
+		return ErrUnknownClient
+	}
+
+	if kicked.session != nil {
+		m.endSession(kicked.session)
+	}
+	kicked.close(websocket.ClosePolicyViolation, reason)
+	return nil
+}
+
+// Announce sends a system message to everyone in room, or to every connected client if room is empty.
+// It returns how many clients it was sent to
+func (m *Manager) Announce(room, message string) int {
+	var clients []*Client
+	if room != "" {
+		clients = m.rooms.Members(room)
+	} else {
+		m.RLock()
+		clients = make([]*Client, 0, len(m.clients))
+		for client := range m.clients {
+			clients = append(clients, client)
+		}
+		m.RUnlock()
+	}
+
+	event := Event{Type: EventSystemMessage, Payload: NewPayload(SystemMessageEvent{Message: message, Sent: time.Now()})}
+	for _, client := range clients {
+		client.Send(event)
+	}
+	return len(clients)
+}
+
+// RevokeOTPs revokes the unused OTPs of username, or every unused OTP if username is empty.
+// The sessions created by the same logins are ended too, so they can not be used to connect either.
+// It returns how many OTPs was revoked
+func (m *Manager) RevokeOTPs(username string) int {
+	revoked := m.otps.Revoke(username)
+	for _, o := range revoked {
+		if s, ok := m.session(o.Session); ok {
+			m.endSession(s)
+		}
+	}
+	return len(revoked)
+}
+
+// AdminHandler serves the admin API, every request needs the basic auth of a user with AdminRole.
+// Mount it under any prefix with http.StripPrefix, the binary serves it on /admin/
+//
+//	GET  /clients      the connected clients
+//	GET  /rooms        the rooms and their members
+//	POST /kick         {"id": "...", "reason": "..."} closes a connection
+//	POST /broadcast    {"room": "...", "message": "..."} sends a system message, to everyone without a room
+//	POST /revoke-otps  {"user": "..."} revokes the unused OTPs of the user, or all of them without a user
+func (m *Manager) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clients", m.admin(http.MethodGet, m.adminClients))
+	mux.HandleFunc("/rooms", m.admin(http.MethodGet, m.adminRooms))
+	mux.HandleFunc("/kick", m.admin(http.MethodPost, m.adminKick))
+	mux.HandleFunc("/broadcast", m.admin(http.MethodPost, m.adminBroadcast))
+	mux.HandleFunc("/revoke-otps", m.admin(http.MethodPost, m.adminRevokeOTPs))
+	return mux
+}
+
+// admin only lets requests using method through to next if they are made by a admin
+func (m *Manager) admin(method string, next func(http.ResponseWriter, *http.Request, Identity)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed, use "+method)
+			return
+		}
+
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized", "basic auth is required")
+			return
+		}
+		// The same backend as the login, so failed attempts are locked out the same way
+		identity, err := m.authenticate(r, username, password)
+		switch {
+		case errors.Is(err, ErrInvalidCredentials):
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized", err.Error())
+			return
+		case errors.Is(err, ErrTooManyAttempts):
+			writeJSONError(w, http.StatusTooManyRequests, "too_many_attempts", err.Error())
+			return
+		case err != nil:
+			m.logger.Error("failed to authenticate", "user", username, "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "failed to authenticate")
+			return
+		}
+		if !identity.HasRole(AdminRole) {
+			writeJSONError(w, http.StatusForbidden, ErrCodeForbidden, "the admin role is required")
+			return
+		}
+		next(w, r, identity)
+	}
+}
+
+func (m *Manager) adminClients(w http.ResponseWriter, r *http.Request, admin Identity) {
+	writeJSON(w, http.StatusOK, struct {
+		Clients []ClientInfo `json:"clients"`
+	}{m.Clients()})
+}
+
+func (m *Manager) adminRooms(w http.ResponseWriter, r *http.Request, admin Identity) {
+	writeJSON(w, http.StatusOK, struct {
+		Rooms []RoomInfo `json:"rooms"`
+	}{m.Rooms()})
+}
+
+func (m *Manager) adminKick(w http.ResponseWriter, r *http.Request, admin Identity) {
+	var req struct {
+		ID     string `json:"id"`
+		Reason string `json:"reason"`
+	}
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+
+	switch err := m.Kick(req.ID, req.Reason); {
+	case errors.Is(err, ErrUnknownClient):
+		writeJSONError(w, http.StatusNotFound, "unknown_client", err.Error())
+	case err != nil:
+		writeJSONError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+	default:
+		m.logger.Info("kicked client", "admin", admin.Username, "conn", req.ID, "reason", req.Reason)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (m *Manager) adminBroadcast(w http.ResponseWriter, r *http.Request, admin Identity) {
+	var req struct {
+		Room    string `json:"room"`
+		Message string `json:"message"`
+	}
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	if req.Message == "" {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeBadRequest, "message can not be empty")
+		return
+	}
+
+	recipients := m.Announce(req.Room, req.Message)
+	m.logger.Info("sent system message", "admin", admin.Username, "room", req.Room, "recipients", recipients)
+	writeJSON(w, http.StatusOK, struct {
+		Recipients int `json:"recipients"`
+	}{recipients})
+}
+
+func (m *Manager) adminRevokeOTPs(w http.ResponseWriter, r *http.Request, admin Identity) {
+	var req struct {
+		User string `json:"user"`
+	}
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+
+	revoked := m.RevokeOTPs(req.User)
+	m.logger.Info("revoked otps", "admin", admin.Username, "user", req.User, "revoked", revoked)
+	writeJSON(w, http.StatusOK, struct {
+		Revoked int `json:"revoked"`
+	}{revoked})
+}
+
+// decodeAdminRequest decodes the JSON body into v, a empty body leaves v as it is.
+// It answers the request and returns false if the body is not valid
+func decodeAdminRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+// writeJSON responds with status and v as the JSON body
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
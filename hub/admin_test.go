@@ -0,0 +1,179 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newAdminTestServer starts a test server where percy is a admin and anna is not,
+// and a second server with the admin API on /admin/
+func newAdminTestServer(t *testing.T) (*Manager, *httptest.Server, *httptest.Server) {
+	t.Helper()
+	auth := NewMemoryAuthenticator()
+	auth.AddUser("percy", "123", AdminRole)
+	auth.AddUser("anna", "123")
+	m, srv := newTestServerWithAuth(t, auth)
+
+	admin := httptest.NewServer(http.StripPrefix("/admin", m.AdminHandler()))
+	t.Cleanup(admin.Close)
+	return m, srv, admin
+}
+
+// adminRequest calls the admin API as username and decodes the response into v if it is not nil
+func adminRequest(t *testing.T, srv *httptest.Server, method, path, username string, body, v any) int {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req, err := http.NewRequest(method, srv.URL+"/admin"+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "" {
+		req.SetBasicAuth(username, "123")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestManager_AdminAuth(t *testing.T) {
+	_, _, admin := newAdminTestServer(t)
+
+	for _, tc := range []struct {
+		name, method, path, user string
+		want                     int
+	}{
+		{"no credentials", http.MethodGet, "/clients", "", http.StatusUnauthorized},
+		{"not a admin", http.MethodGet, "/clients", "anna", http.StatusForbidden},
+		{"unknown user", http.MethodGet, "/clients", "bob", http.StatusUnauthorized},
+		{"wrong method", http.MethodGet, "/kick", "percy", http.StatusMethodNotAllowed},
+		{"unknown route", http.MethodGet, "/nothing", "percy", http.StatusNotFound},
+		{"admin", http.MethodGet, "/clients", "percy", http.StatusOK},
+	} {
+		if got := adminRequest(t, admin, tc.method, tc.path, tc.user, nil, nil); got != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestManager_AdminAPI(t *testing.T) {
+	m, srv, admin := newAdminTestServer(t)
+
+	percy := connect(t, srv, "percy")
+	_, session := loginSession(t, srv, "anna")
+	anna, _, err := resume(srv, session, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { anna.Close() })
+	waitForClients(t, m, 2)
+
+	var clients struct {
+		Clients []ClientInfo `json:"clients"`
+	}
+	if status := adminRequest(t, admin, http.MethodGet, "/clients", "percy", nil, &clients); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if len(clients.Clients) != 2 {
+		t.Fatalf("expected 2 clients, got %+v", clients.Clients)
+	}
+	var annaID string
+	for _, client := range clients.Clients {
+		if client.ID == "" || client.Room != DefaultRoom || client.RemoteAddr == "" || client.ConnectedSince.IsZero() {
+			t.Errorf("expected the client to be described, got %+v", client)
+		}
+		if client.User == "anna" {
+			annaID = client.ID
+		}
+	}
+
+	var rooms struct {
+		Rooms []RoomInfo `json:"rooms"`
+	}
+	adminRequest(t, admin, http.MethodGet, "/rooms", "percy", nil, &rooms)
+	if len(rooms.Rooms) != 1 || rooms.Rooms[0].Name != DefaultRoom || len(rooms.Rooms[0].Members) != 2 {
+		t.Errorf("expected both users in the default room, got %+v", rooms.Rooms)
+	}
+
+	var broadcast struct {
+		Recipients int `json:"recipients"`
+	}
+	adminRequest(t, admin, http.MethodPost, "/broadcast", "percy", map[string]string{"message": "maintenance at noon"}, &broadcast)
+	if broadcast.Recipients != 2 {
+		t.Errorf("expected the message to reach 2 clients, got %d", broadcast.Recipients)
+	}
+	for _, conn := range []*websocket.Conn{percy, anna} {
+		var msg SystemMessageEvent
+		readUntil(t, conn, EventSystemMessage, &msg)
+		if msg.Message != "maintenance at noon" {
+			t.Errorf("expected the system message, got %q", msg.Message)
+		}
+	}
+
+	if status := adminRequest(t, admin, http.MethodPost, "/kick", "percy", map[string]string{"id": "nobody"}, nil); status != http.StatusNotFound {
+		t.Errorf("expected kicking a unknown client to be 404, got %d", status)
+	}
+	if status := adminRequest(t, admin, http.MethodPost, "/kick", "percy", map[string]string{"id": annaID, "reason": "be nice"}, nil); status != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", status)
+	}
+	anna.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := anna.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "be nice" {
+			t.Fatalf("expected to be closed with 1008 and the reason, got %v", err)
+		}
+		break
+	}
+	waitForClients(t, m, 1)
+	waitFor(t, "anna to leave the room", func() bool { return len(m.rooms.Usernames(DefaultRoom)) == 1 })
+	// The session ended with the connection
+	if _, resp, err := resume(srv, session, 0); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected the session of a kicked client to be gone, got %v", err)
+	}
+}
+
+func TestManager_AdminRevokeOTPs(t *testing.T) {
+	m, srv, admin := newAdminTestServer(t)
+
+	login(t, srv, "percy", "123")
+	login(t, srv, "anna", "123")
+	_, session := loginSession(t, srv, "anna")
+
+	var revoked struct {
+		Revoked int `json:"revoked"`
+	}
+	adminRequest(t, admin, http.MethodPost, "/revoke-otps", "percy", map[string]string{"user": "anna"}, &revoked)
+	if revoked.Revoked != 2 || m.otps.Len() != 1 {
+		t.Errorf("expected the 2 OTPs of anna to be revoked, got %d with %d left", revoked.Revoked, m.otps.Len())
+	}
+	// The session of the same login can not be used instead
+	if _, resp, err := resume(srv, session, 0); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected the session of a revoked OTP to be gone, got %v", err)
+	}
+	// Without a body every OTP is revoked
+	adminRequest(t, admin, http.MethodPost, "/revoke-otps", "percy", nil, &revoked)
+	if revoked.Revoked != 1 || m.otps.Len() != 0 {
+		t.Errorf("expected the last OTP to be revoked, got %d with %d left", revoked.Revoked, m.otps.Len())
+	}
+}
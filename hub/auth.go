@@ -0,0 +1,243 @@
+package hub
+
+import (
+	"crypto/subtle"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrInvalidCredentials is returned when the username or password is wrong
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrTooManyAttempts is returned when a user has failed to login too many times
+	ErrTooManyAttempts = errors.New("too many failed login attempts")
+)
+
+// Identity is the authenticated user behind a login
+type Identity struct {
+	Username string   `json:"username"`
+	Roles    []string `json:"roles,omitempty"`
+}
+
+// HasRole reports if the identity has been granted role
+func (i Identity) HasRole(role string) bool {
+	for _, r := range i.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator is used by the LoginHandler to verify the credentials of a user.
+// It should return ErrInvalidCredentials if the credentials are wrong, ErrTooManyAttempts
+// if the user should slow down, any other error is treated as a internal failure
+type Authenticator interface {
+	Authenticate(username, password string) (Identity, error)
+}
+
+// UserDirectory is implemented by authenticators that can tell if a user exists without a password.
+// It is used to refuse direct messages to users that do not exist, without it only connected users can be reached
+type UserDirectory interface {
+	UserExists(username string) (bool, error)
+}
+
+// dummyHash is compared against when a user does not exist, so that a missing user
+// takes as long to reject as a wrong password and usernames cant be guessed by timing.
+// It is hashed the first time it is needed, not when the package is loaded
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	if err != nil {
+		// Only a broken crypto/rand gets here, without a hash missing users would be quick to reject
+		panic("failed to hash the dummy password: " + err.Error())
+	}
+	return hash
+})
+
+// compareBcrypt checks password against a bcrypt hash and maps the result into our errors
+func compareBcrypt(hash []byte, password string) error {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return ErrInvalidCredentials
+	default:
+		return err
+	}
+}
+
+// MemoryAuthenticator keeps users and plain passwords in memory, it is meant for tests and examples
+type MemoryAuthenticator struct {
+	sync.RWMutex
+	users map[string]memoryUser
+}
+
+type memoryUser struct {
+	password string
+	identity Identity
+}
+
+// NewMemoryAuthenticator creates a empty MemoryAuthenticator, use AddUser to fill it
+func NewMemoryAuthenticator() *MemoryAuthenticator {
+	return &MemoryAuthenticator{
+		users: make(map[string]memoryUser),
+	}
+}
+
+// AddUser will add or replace a user
+func (a *MemoryAuthenticator) AddUser(username, password string, roles ...string) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.users[username] = memoryUser{
+		password: password,
+		identity: Identity{Username: username, Roles: roles},
+	}
+}
+
+// Authenticate implements Authenticator
+func (a *MemoryAuthenticator) Authenticate(username, password string) (Identity, error) {
+	a.RLock()
+	user, ok := a.users[username]
+	a.RUnlock()
+
+	if !ok || subtle.ConstantTimeCompare([]byte(user.password), []byte(password)) != 1 {
+		return Identity{}, ErrInvalidCredentials
+	}
+	return user.identity, nil
+}
+
+// UserExists implements UserDirectory
+func (a *MemoryAuthenticator) UserExists(username string) (bool, error) {
+	a.RLock()
+	defer a.RUnlock()
+
+	_, ok := a.users[username]
+	return ok, nil
+}
+
+// RemoteAuthenticator is implemented by authenticators that take the address of the client into account.
+// The login and the admin API use it instead of Authenticate when it is available
+type RemoteAuthenticator interface {
+	AuthenticateFrom(remote, username, password string) (Identity, error)
+}
+
+// LockoutAuthenticator wraps another Authenticator and stops a client address from trying
+// a username again once it has failed too many times within a window. It is keyed by address
+// and username together, so nobody can lock a user out from everywhere by guessing wrong on purpose
+type LockoutAuthenticator struct {
+	next        Authenticator
+	maxFailures int
+	window      time.Duration
+
+	mu       sync.Mutex
+	failures map[lockoutKey]*loginFailures
+	// lastSweep is when the failures of passed windows were last forgotten
+	lastSweep time.Time
+}
+
+// lockoutKey is what failed attempts are counted by
+type lockoutKey struct {
+	remote   string
+	username string
+}
+
+type loginFailures struct {
+	count int
+	first time.Time
+}
+
+// Make sure LockoutAuthenticator is used with the address of the client
+var _ RemoteAuthenticator = (*LockoutAuthenticator)(nil)
+
+// NewLockoutAuthenticator allows maxFailures failed attempts per client address and username within window
+func NewLockoutAuthenticator(next Authenticator, maxFailures int, window time.Duration) *LockoutAuthenticator {
+	return &LockoutAuthenticator{
+		next:        next,
+		maxFailures: maxFailures,
+		window:      window,
+		failures:    make(map[lockoutKey]*loginFailures),
+	}
+}
+
+// Authenticate implements Authenticator, all attempts without a address share the same count
+func (a *LockoutAuthenticator) Authenticate(username, password string) (Identity, error) {
+	return a.AuthenticateFrom("", username, password)
+}
+
+// AuthenticateFrom implements RemoteAuthenticator. Every attempt is counted as a failure up front
+// under the lock, so concurrent attempts can not get past maxFailures, and refunded unless the password was wrong
+func (a *LockoutAuthenticator) AuthenticateFrom(remote, username, password string) (Identity, error) {
+	key := lockoutKey{remote: remote, username: username}
+	if !a.reserve(key, time.Now()) {
+		return Identity{}, ErrTooManyAttempts
+	}
+
+	identity, err := a.next.Authenticate(username, password)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case err == nil:
+		delete(a.failures, key)
+	case errors.Is(err, ErrInvalidCredentials):
+		// Already counted by reserve
+	default:
+		// Not the users fault, give the attempt back
+		if f, ok := a.failures[key]; ok {
+			if f.count--; f.count <= 0 {
+				delete(a.failures, key)
+			}
+		}
+	}
+	return identity, err
+}
+
+// reserve counts a attempt for key, it returns false if key has already failed too many times
+func (a *LockoutAuthenticator) reserve(key lockoutKey, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.sweep(now)
+	f, ok := a.failures[key]
+	if ok && now.Sub(f.first) >= a.window {
+		// The window has passed, start counting again
+		ok = false
+	}
+	if !ok {
+		f = &loginFailures{first: now}
+		a.failures[key] = f
+	}
+	if f.count >= a.maxFailures {
+		return false
+	}
+	f.count++
+	return true
+}
+
+// sweep forgets the failures whose window has passed, so random usernames can not grow the map forever.
+// It runs at most once per window
+func (a *LockoutAuthenticator) sweep(now time.Time) {
+	if now.Sub(a.lastSweep) < a.window {
+		return
+	}
+	a.lastSweep = now
+	for key, f := range a.failures {
+		if now.Sub(f.first) >= a.window {
+			delete(a.failures, key)
+		}
+	}
+}
+
+// UserExists asks the wrapped Authenticator, it returns errors.ErrUnsupported if that is not a UserDirectory
+func (a *LockoutAuthenticator) UserExists(username string) (bool, error) {
+	directory, ok := a.next.(UserDirectory)
+	if !ok {
+		return false, errors.ErrUnsupported
+	}
+	return directory.UserExists(username)
+}
@@ -0,0 +1,177 @@
+package hub
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// fileUser is a single entry in a user file
+type fileUser struct {
+	Username     string   `json:"username"`
+	PasswordHash string   `json:"password_hash"`
+	Roles        []string `json:"roles,omitempty"`
+}
+
+// FileAuthenticator authenticates against a JSON user file holding bcrypt hashed passwords
+//
+//	[{"username": "percy", "password_hash": "$2a$10$...", "roles": ["admin"]}]
+type FileAuthenticator struct {
+	path string
+
+	sync.RWMutex
+	users map[string]fileUser
+}
+
+// NewFileAuthenticator loads the user file found at path
+func NewFileAuthenticator(path string) (*FileAuthenticator, error) {
+	a := &FileAuthenticator{path: path}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the user file again, use it to pick up changed users without a restart
+func (a *FileAuthenticator) Reload() error {
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to read user file: %w", err)
+	}
+
+	var list []fileUser
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("failed to parse user file %s: %w", a.path, err)
+	}
+
+	users := make(map[string]fileUser, len(list))
+	for _, u := range list {
+		if u.Username == "" || u.PasswordHash == "" {
+			return fmt.Errorf("user file %s has a entry without username or password_hash", a.path)
+		}
+		users[u.Username] = u
+	}
+
+	a.Lock()
+	a.users = users
+	a.Unlock()
+	return nil
+}
+
+// Authenticate implements Authenticator
+func (a *FileAuthenticator) Authenticate(username, password string) (Identity, error) {
+	a.RLock()
+	user, ok := a.users[username]
+	a.RUnlock()
+
+	if !ok {
+		// Spend the same time as a real comparison before saying no
+		compareBcrypt(dummyHash(), password)
+		return Identity{}, ErrInvalidCredentials
+	}
+	if err := compareBcrypt([]byte(user.PasswordHash), password); err != nil {
+		return Identity{}, err
+	}
+	return Identity{Username: user.Username, Roles: user.Roles}, nil
+}
+
+// UserExists implements UserDirectory
+func (a *FileAuthenticator) UserExists(username string) (bool, error) {
+	a.RLock()
+	defer a.RUnlock()
+
+	_, ok := a.users[username]
+	return ok, nil
+}
+
+// HtpasswdAuthenticator authenticates against a Apache htpasswd file.
+// Only bcrypt ($2y$, $2a$, $2b$) and {SHA} entries are supported
+type HtpasswdAuthenticator struct {
+	path string
+
+	sync.RWMutex
+	hashes map[string]string
+}
+
+// NewHtpasswdAuthenticator loads the htpasswd file found at path
+func NewHtpasswdAuthenticator(path string) (*HtpasswdAuthenticator, error) {
+	a := &HtpasswdAuthenticator{path: path}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the htpasswd file again
+func (a *HtpasswdAuthenticator) Reload() error {
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+
+	hashes := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok || username == "" || hash == "" {
+			return fmt.Errorf("htpasswd file %s line %d is malformed", a.path, i+1)
+		}
+		if !isBcryptHash(hash) && !strings.HasPrefix(hash, "{SHA}") {
+			return fmt.Errorf("htpasswd file %s line %d uses a unsupported hash, only bcrypt and {SHA} are supported", a.path, i+1)
+		}
+		hashes[username] = hash
+	}
+
+	a.Lock()
+	a.hashes = hashes
+	a.Unlock()
+	return nil
+}
+
+// Authenticate implements Authenticator
+func (a *HtpasswdAuthenticator) Authenticate(username, password string) (Identity, error) {
+	a.RLock()
+	hash, ok := a.hashes[username]
+	a.RUnlock()
+
+	if !ok {
+		compareBcrypt(dummyHash(), password)
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	if isBcryptHash(hash) {
+		// htpasswd writes $2y$ which is the same algorithm as $2a$
+		if err := compareBcrypt([]byte(strings.Replace(hash, "$2y$", "$2a$", 1)), password); err != nil {
+			return Identity{}, err
+		}
+		return Identity{Username: username}, nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) != 1 {
+		return Identity{}, ErrInvalidCredentials
+	}
+	return Identity{Username: username}, nil
+}
+
+// UserExists implements UserDirectory
+func (a *HtpasswdAuthenticator) UserExists(username string) (bool, error) {
+	a.RLock()
+	defer a.RUnlock()
+
+	_, ok := a.hashes[username]
+	return ok, nil
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$")
+}
@@ -0,0 +1,177 @@
+package hub
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeTempFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAuthenticators(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	memory := NewMemoryAuthenticator()
+	memory.AddUser("percy", "123", "admin")
+
+	file, err := NewFileAuthenticator(writeTempFile(t, "users.json",
+		`[{"username": "percy", "password_hash": "`+string(hash)+`", "roles": ["admin"]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// {SHA} of 123 as written by htpasswd -s
+	htpasswd, err := NewHtpasswdAuthenticator(writeTempFile(t, ".htpasswd",
+		"# users\npercy:$2y$"+string(hash[4:])+"\nanna:{SHA}QL0AFWMIX8NRZTKeof9cXsvbvu8=\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		auth     Authenticator
+		username string
+		password string
+		err      error
+	}{
+		{name: "memory", auth: memory, username: "percy", password: "123"},
+		{name: "memory wrong password", auth: memory, username: "percy", password: "1234", err: ErrInvalidCredentials},
+		{name: "memory missing user", auth: memory, username: "anna", password: "123", err: ErrInvalidCredentials},
+		{name: "file", auth: file, username: "percy", password: "123"},
+		{name: "file wrong password", auth: file, username: "percy", password: "1234", err: ErrInvalidCredentials},
+		{name: "file missing user", auth: file, username: "anna", password: "123", err: ErrInvalidCredentials},
+		{name: "htpasswd bcrypt", auth: htpasswd, username: "percy", password: "123"},
+		{name: "htpasswd sha", auth: htpasswd, username: "anna", password: "123"},
+		{name: "htpasswd wrong password", auth: htpasswd, username: "anna", password: "1234", err: ErrInvalidCredentials},
+		{name: "htpasswd missing user", auth: htpasswd, username: "bob", password: "123", err: ErrInvalidCredentials},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			identity, err := tc.auth.Authenticate(tc.username, tc.password)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected %v, got %v", tc.err, err)
+			}
+			if err == nil && identity.Username != tc.username {
+				t.Errorf("expected identity %q, got %q", tc.username, identity.Username)
+			}
+		})
+	}
+
+	identity, _ := file.Authenticate("percy", "123")
+	if !identity.HasRole("admin") {
+		t.Error("expected the roles from the user file")
+	}
+
+	// Every authenticator can tell who exists, the lockout asks the one it wraps
+	for _, directory := range []UserDirectory{memory, file, htpasswd, NewLockoutAuthenticator(memory, 5, time.Minute)} {
+		if exists, err := directory.UserExists("percy"); !exists || err != nil {
+			t.Errorf("%T: expected percy to exist, got %v %v", directory, exists, err)
+		}
+		if exists, _ := directory.UserExists("bob"); exists {
+			t.Errorf("%T: expected bob to not exist", directory)
+		}
+	}
+}
+
+func TestHtpasswdAuthenticator_UnsupportedHash(t *testing.T) {
+	_, err := NewHtpasswdAuthenticator(writeTempFile(t, ".htpasswd", "percy:$apr1$abc$def\n"))
+	if err == nil {
+		t.Error("expected md5 hashes to be rejected")
+	}
+}
+
+func TestLockoutAuthenticator(t *testing.T) {
+	memory := NewMemoryAuthenticator()
+	memory.AddUser("percy", "123")
+	auth := NewLockoutAuthenticator(memory, 2, 50*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		if _, err := auth.Authenticate("percy", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected invalid credentials, got %v", err)
+		}
+	}
+	if _, err := auth.Authenticate("percy", "123"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("expected to be locked out, got %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := auth.Authenticate("percy", "123"); err != nil {
+		t.Fatalf("expected the lockout to expire, got %v", err)
+	}
+}
+
+func TestLockoutAuthenticator_PerAddress(t *testing.T) {
+	memory := NewMemoryAuthenticator()
+	memory.AddUser("percy", "123")
+	auth := NewLockoutAuthenticator(memory, 2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		auth.AuthenticateFrom("10.0.0.1", "percy", "wrong")
+	}
+	if _, err := auth.AuthenticateFrom("10.0.0.1", "percy", "123"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("expected the guessing address to be locked out, got %v", err)
+	}
+	// Someone else guessing wrong does not lock percy out everywhere
+	if _, err := auth.AuthenticateFrom("10.0.0.2", "percy", "123"); err != nil {
+		t.Fatalf("expected percy to login from another address, got %v", err)
+	}
+}
+
+// slowAuthenticator rejects every password after a while, so attempts overlap
+type slowAuthenticator struct{}
+
+func (slowAuthenticator) Authenticate(string, string) (Identity, error) {
+	time.Sleep(10 * time.Millisecond)
+	return Identity{}, ErrInvalidCredentials
+}
+
+func TestLockoutAuthenticator_Concurrent(t *testing.T) {
+	auth := NewLockoutAuthenticator(slowAuthenticator{}, 2, time.Minute)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	tried := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := auth.AuthenticateFrom("10.0.0.1", "percy", "wrong"); errors.Is(err, ErrInvalidCredentials) {
+				mu.Lock()
+				tried++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if tried != 2 {
+		t.Errorf("expected only 2 attempts to reach the backend, got %d", tried)
+	}
+}
+
+func TestLockoutAuthenticator_Sweep(t *testing.T) {
+	auth := NewLockoutAuthenticator(NewMemoryAuthenticator(), 5, time.Minute)
+	now := time.Now()
+	for _, username := range []string{"a", "b", "c"} {
+		auth.reserve(lockoutKey{remote: "10.0.0.1", username: username}, now)
+	}
+	auth.reserve(lockoutKey{remote: "10.0.0.1", username: "d"}, now.Add(2*time.Minute))
+	if len(auth.failures) != 1 {
+		t.Errorf("expected the failures of passed windows to be forgotten, got %d left", len(auth.failures))
+	}
+}
@@ -0,0 +1,296 @@
+package hub
+
+import ( //@进口
+	"context"
+	"log/slog"
+	"sync"
+	"time" //@时间
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket" //@github com 大猩猩 websocket
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ClientList is a map used to help manage a map of clients //@客户列表是用于帮助管理客户地图的地图
+type ClientList map[*Client]bool //@类型客户端列表映射客户端布尔
+
+// Client is a websocket client, basically a frontend visitor //@客户端是一个 websocket 客户端，基本上是一个前端访问者
+type Client struct { //@类型客户端结构
+	// the websocket connection //@网络套接字连接
+	connection *websocket.Conn //@连接 websocket conn
+
+	// manager is the manager used to manage the client //@manager 是用来管理client的manager
+	manager *Manager //@经理经理
+	// egress is used to avoid concurrent writes on the WebSocket //@出口用于避免在网络套接字上并发写入
+	// It is buffered so one slow client can not hold up the sender, see Send
+	egress chan Event //@出口陈事件
+	// done is closed when the client is shutting down, nothing should be queued after that
+	done      chan struct{}
+	closeOnce sync.Once
+	// ctx lives as long as the client, it is cancelled by close
+	ctx    context.Context
+	cancel context.CancelFunc
+	// closeCode and closeReason are written in the close frame, set once by close
+	closeCode   int
+	closeReason string
+	// slow is 1 while the client is marked as a slow consumer, only use with sync/atomic
+	slow int32
+	// identity is the authenticated user that owns the connection
+	identity Identity
+	// id tells connections apart in logs, a user can have many
+	id string
+	// remoteAddr is the address the connection comes from
+	remoteAddr string
+	// created is when the connection was opened
+	created time.Time
+	// session numbers and buffers what is sent, it is nil for clients created outside ServeWS
+	session *session
+	// replay are events written before anything queued, set when resuming a session
+	replay []Event
+	// typing tracks the typing indicator of the client
+	typing typingState
+	// codec encodes the events of the connection, it is picked from the subprotocol
+	codec Codec
+	// compress is true when permessage-deflate was agreed on with the client
+	compress bool
+	// wire counts the bytes written to the network, it is nil for clients created outside ServeWS
+	wire *countingConn
+	// compressionMetrics counts the bytes written before and after compression
+	compressionMetrics compressionMetrics
+}
+
+// NewClient is used to initialize a new Client with all required values initialized //@new client 用于初始化一个新的客户端，并初始化所有需要的值
+func NewClient(conn *websocket.Conn, manager *Manager, identity Identity) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	var remoteAddr string
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+	return &Client{ //@回头客
+		connection: conn, //@连接conn
+		manager:    manager, //@经理经理
+		egress:     make(chan Event, manager.config.Egress.QueueSize),
+		done:       make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+		identity:   identity,
+		id:         uuid.NewString(),
+		remoteAddr: remoteAddr,
+		created:    time.Now(),
+		codec:      JSONCodec,
+	}
+}
+
+// Context is cancelled when the client disconnects, use it to stop work done on behalf of the client
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// ID returns the id of the connection, it is unique even when a user has many connections
+func (c *Client) ID() string {
+	return c.id
+}
+
+// Logger returns the logger of the manager with the connection id, user, room and remote address of the client
+func (c *Client) Logger() *slog.Logger {
+	return c.manager.logger.With(c.logAttrs()...)
+}
+
+// logAttrs are the attributes added to every line logged about the client
+func (c *Client) logAttrs() []any {
+	return []any{"conn", c.id, "user", c.identity.Username, "room", c.Room(), "remote", c.remoteAddr}
+}
+
+// log logs about the client, the attributes are only looked up if the level is enabled
+// as some of it is logged for every frame
+func (c *Client) log(level slog.Level, msg string, args ...any) {
+	logger := c.manager.logger
+	if !logger.Enabled(context.Background(), level) {
+		return
+	}
+	logger.Log(context.Background(), level, msg, append(c.logAttrs(), args...)...)
+}
+
+// Identity returns the authenticated user behind the client
+func (c *Client) Identity() Identity {
+	return c.identity
+}
+
+// Room returns the name of the room the client is in, empty if it has left
+func (c *Client) Room() string {
+	room, _ := c.manager.rooms.RoomOf(c)
+	return room
+}
+
+// readMessages will start the client to read messages and handle them //@读取消息将启动客户端读取消息并处理它们
+// appropriatly. //@恰当地
+// This is suppose to be ran as a goroutine //@这应该作为 goroutine 运行
+func (c *Client) readMessages() { //@func c 客户端读取消息
+	defer func() { //@延迟函数
+		// Graceful Close the Connection once this //@优雅地关闭连接一次
+		// function is done //@功能完成
+		c.manager.removeClient(c) //@c经理删除客户c
+	}()
+	// Set Max Size of Messages in Bytes //@以字节为单位设置消息的最大大小
+	c.connection.SetReadLimit(c.manager.config.ReadLimit)
+	// Configure Wait time for Pong response, use Current time + pongWait //@配置乒乓响应的等待时间使用当前时间乒乓等待
+	// This has to be done here to set the first initial timer. //@这必须在此处完成以设置第一个初始计时器
+	if err := c.connection.SetReadDeadline(time.Now().Add(c.manager.config.PongWait)); err != nil {
+		c.log(slog.LevelError, "failed to set read deadline", "err", err)
+		return //@返回
+	}
+	// Configure how to handle Pong responses //@配置如何处理 pong 响应
+	c.connection.SetPongHandler(c.pongHandler) //@c 连接设置 pong 处理程序 c pong 处理程序
+
+	// Loop Forever //@永远循环
+	for { //@为了
+		// ReadMessage is used to read the next message in queue //@读取消息用于读取队列中的下一条消息
+		// in the connection //@在连接
+		_, payload, err := c.connection.ReadMessage() //@payload err c 连接读取消息
+
+		if err != nil { //@如果错误为零
+			// If Connection is closed, we will Recieve an error here //@如果连接关闭，我们将在此处收到错误消息
+			// We only want to log Strange errors, but simple Disconnection //@我们只想记录奇怪的错误但简单的断开连接
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) { //@if websocket is unexpected close error err websocket close going away websocket close 异常关闭
+				c.log(slog.LevelWarn, "connection closed unexpectedly", "err", err)
+			}
+			break // Break the loop to close conn & Cleanup //@break 打破循环以关闭 conn 清理
+		}
+		// A user flooding from one or many connections is stopped before anything is decoded or handled
+		if !c.allowFrame() {
+			continue
+		}
+		// Decode incoming data into a Event struct, the payload is left for the handler
+		request, err := unmarshalEvent(c.codec, payload)
+		if err != nil {
+			c.manager.metrics.eventsReceived.inc(invalidEventType)
+			c.log(slog.LevelWarn, "message is not a valid event", "err", err)
+			// Let the frontend know instead of dropping the connection
+			c.sendError(Event{}, NewHandlerError(ErrCodeBadRequest, "message is not a valid event"))
+			continue
+		}
+		c.manager.metrics.eventsReceived.inc(c.manager.eventLabel(request.Type))
+		if !c.allowEvent(request) {
+			continue
+		}
+		// Route the Event //@路由事件
+		c.handle(request)
+	}
+}
+
+// close tells the writer to send a close frame with code and reason and shut down.
+// Only the first call has any effect
+func (c *Client) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.done)
+		c.cancel()
+	})
+}
+
+// pongHandler is used to handle PongMessages for the Client //@pong 处理程序用于为客户端处理 pong 消息
+func (c *Client) pongHandler(pongMsg string) error { //@func c 客户端 pong 处理程序 pong 消息字符串错误
+	// Current time + Pong Wait time //@当前时间乒乓等待时间
+	c.log(slog.LevelDebug, "pong")
+	return c.connection.SetReadDeadline(time.Now().Add(c.manager.config.PongWait))
+}
+
+// writeMessages is a process that listens for new messages to output to the Client //@write messages是一个监听新消息输出到客户端的进程
+func (c *Client) writeMessages() { //@func c 客户端写消息
+	// Create a ticker that triggers a ping at given interval //@创建一个在给定时间间隔触发 ping 的自动收报机
+	ticker := time.NewTicker(c.manager.config.pingInterval())
+	defer func() { //@延迟函数
+		ticker.Stop() //@股票止损
+		// Graceful close if this triggers a closing //@如果这触发关闭，则优雅关闭
+		c.manager.removeClient(c) //@c经理删除客户c
+		c.manager.writers.Done()
+	}()
+
+	// Catch up a resumed session before anything new is written
+	for _, event := range c.replay {
+		if err := c.writeEvent(event); err != nil {
+			c.log(slog.LevelWarn, "failed to replay session", "err", err)
+			return
+		}
+	}
+	c.replay = nil
+
+	for { //@为了
+		select { //@选择
+		case message, ok := <-c.egress: //@案例消息 ok c egress
+			// Ok will be false Incase the egress channel is closed //@如果出口通道关闭，ok 将是 false
+			if !ok { //@如果可以的话
+				// Manager has closed this connection channel, so communicate that to frontend //@经理已关闭此连接通道，因此请将其传达给前端
+				if err := c.connection.WriteMessage(websocket.CloseMessage, nil); err != nil { //@如果错误 c 连接写入消息 websocket 关闭消息 nil err nil
+					// Log that the connection is closed and the reason //@记录连接关闭和原因
+					c.log(slog.LevelDebug, "failed to write close frame", "err", err)
+				}
+				// Return to close the goroutine //@返回关闭 goroutine
+				return //@返回
+			}
+
+			// Write the message in the encoding of the connection
+			if err := c.writeEvent(message); err != nil {
+				c.log(slog.LevelWarn, "failed to write event", "event", message.Type, "err", err)
+				// The connection is broken after a failed write, tear the client down
+				return
+			}
+			c.log(slog.LevelDebug, "sent event", "event", message.Type)
+			c.caughtUp()
+		case <-c.done:
+			// Write what is still queued, such as the going away event, before closing
+			c.flush(time.Now().Add(time.Second))
+			// The client is being closed, tell the frontend why
+			msg := websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+			if err := c.connection.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+				c.log(slog.LevelDebug, "failed to write close frame", "err", err)
+			}
+			return
+		case <-ticker.C: //@案例代码 c
+			c.log(slog.LevelDebug, "ping")
+			// Send the Ping //@发送 ping
+			if err := c.connection.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
+				c.log(slog.LevelWarn, "failed to ping", "err", err)
+				return // return to break this goroutine triggeing cleanup //@return return 中断这个 goroutine 触发清理
+			}
+		}
+
+	}
+}
+
+// flush writes the events left in the egress queue, it gives up at deadline
+func (c *Client) flush(deadline time.Time) {
+	c.connection.SetWriteDeadline(deadline)
+	for {
+		select {
+		case message := <-c.egress:
+			if err := c.writeEvent(message); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// writeEvent encodes the event with the codec of the client and writes it.
+// A event that can not be encoded is logged and skipped, it is not worth dropping the connection for
+func (c *Client) writeEvent(event Event) error {
+	span := c.traceWrite(event)
+	defer span.End()
+
+	data, err := marshalEvent(c.codec, event)
+	if err != nil {
+		c.log(slog.LevelError, "failed to encode event", "event", event.Type, "err", err)
+		spanError(span, err)
+		return nil
+	}
+	span.SetAttributes(attribute.Int("bytes", len(data)))
+	if err := c.writeFrame(c.codec.MessageType(), data); err != nil {
+		spanError(span, err)
+		return err
+	}
+	c.manager.metrics.eventsSent.inc(event.Type)
+	return nil
+}
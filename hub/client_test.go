@@ -0,0 +1,57 @@
+package hub
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logBuffer collects log lines, the hub logs from many goroutines
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *logBuffer) Write(p []byte) (int, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.Write(p)
+}
+
+func (lb *logBuffer) String() string {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.String()
+}
+
+func TestClient_Logging(t *testing.T) {
+	var logs logBuffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	m, srv := newTestServer(t, WithLogger(logger), WithPongWait(200*time.Millisecond, 50*time.Millisecond))
+	conn := connect(t, srv, "percy")
+	waitForClients(t, m, 1)
+	client := clientOf(t, m, "percy")
+
+	// Let a few pings and messages go by, they are only logged at debug
+	sendEvent(t, conn, EventSendMessage, SendMessageEvent{Message: "hello"})
+	readUntil(t, conn, EventNewMessage, nil)
+	time.Sleep(150 * time.Millisecond)
+
+	var connected string
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "msg=ping") || strings.Contains(line, "msg=pong") || strings.Contains(line, `msg="sent event"`) {
+			t.Errorf("expected frames to only be logged at debug, got %q", line)
+		}
+		if strings.Contains(line, "msg=connected") {
+			connected = line
+		}
+	}
+	for _, attr := range []string{"conn=" + client.ID(), "user=percy", "room=" + DefaultRoom, "remote=127.0.0.1:"} {
+		if !strings.Contains(connected, attr) {
+			t.Errorf("expected %s in %q", attr, connected)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// Codec encodes the events of a connection. The codec is picked per connection from the
+// Sec-WebSocket-Protocol the client asks for, clients that ask for nothing get JSONCodec
+type Codec interface {
+	// Subprotocol is the name a client asks for to use the codec
+	Subprotocol() string
+	// MessageType is the websocket frame type used, websocket.TextMessage or websocket.BinaryMessage
+	MessageType() int
+	// Marshal encodes a payload
+	Marshal(v any) ([]byte, error)
+	// Unmarshal decodes a payload into v
+	Unmarshal(data []byte, v any) error
+	// MarshalEvent encodes the event, the payload is already encoded by Marshal and nil if there is none
+	MarshalEvent(event Event, payload []byte) ([]byte, error)
+	// UnmarshalEvent decodes a event, the payload is left encoded so a handler can decode it with Unmarshal
+	UnmarshalEvent(data []byte) (event Event, payload []byte, err error)
+}
+
+// ErrNoPayload is returned when decoding a event that carries no payload
+var ErrNoPayload = errors.New("event has no payload")
+
+// Payload is the data of a event. A received payload stays encoded until a handler decodes it
+// with the codec of the connection it arrived on. A payload created by the server holds the value,
+// so every receiver can encode it with its own codec
+type Payload struct {
+	codec Codec
+	data  []byte
+	value any
+}
+
+// NewPayload creates the payload of a event sent by the server
+func NewPayload(v any) Payload {
+	return Payload{value: v}
+}
+
+// Decode decodes the payload into v
+func (p Payload) Decode(v any) error {
+	if p.codec == nil {
+		if p.value == nil {
+			return ErrNoPayload
+		}
+		// Created on the server, go through JSON like a client would
+		data, err := json.Marshal(p.value)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, v)
+	}
+	// An empty but present payload is fine, protobuf encodes a message with only default values as nothing
+	if p.data == nil {
+		return ErrNoPayload
+	}
+	return p.codec.Unmarshal(p.data, v)
+}
+
+// encode returns the payload encoded by codec, or nil if it is empty
+func (p Payload) encode(codec Codec) ([]byte, error) {
+	switch {
+	case p.codec != nil && p.codec.Subprotocol() == codec.Subprotocol():
+		return p.data, nil
+	case p.codec != nil:
+		// Forwarding a payload from a client using another codec
+		var v any
+		if err := p.codec.Unmarshal(p.data, &v); err != nil {
+			return nil, err
+		}
+		return codec.Marshal(v)
+	case p.value == nil:
+		return nil, nil
+	default:
+		return codec.Marshal(p.value)
+	}
+}
+
+// MarshalJSON encodes the payload as JSON, so a Event can be written with encoding/json
+func (p Payload) MarshalJSON() ([]byte, error) {
+	data, err := p.encode(JSONCodec)
+	if err != nil || data == nil {
+		return []byte("null"), err
+	}
+	return data, nil
+}
+
+// UnmarshalJSON keeps the JSON of the payload for a later Decode
+func (p *Payload) UnmarshalJSON(data []byte) error {
+	*p = Payload{codec: JSONCodec, data: bytes.Clone(data)}
+	return nil
+}
+
+// String returns the payload as JSON, for logging
+func (p Payload) String() string {
+	data, err := p.MarshalJSON()
+	if err != nil {
+		return fmt.Sprintf("<bad payload: %v>", err)
+	}
+	return string(data)
+}
+
+// marshalEvent encodes the event with codec
+func marshalEvent(codec Codec, event Event) ([]byte, error) {
+	payload, err := event.Payload.encode(codec)
+	if err != nil {
+		return nil, err
+	}
+	return codec.MarshalEvent(event, payload)
+}
+
+// unmarshalEvent decodes a event with codec, leaving the payload for the handler to decode
+func unmarshalEvent(codec Codec, data []byte) (Event, error) {
+	event, payload, err := codec.UnmarshalEvent(data)
+	if err != nil {
+		return Event{}, err
+	}
+	event.Payload = Payload{codec: codec, data: payload}
+	return event, nil
+}
+
+// selectCodec picks the first codec the client asks for, in the order the client prefers them.
+// The choice is set in the response header so the upgrader confirms it
+func (m *Manager) selectCodec(r *http.Request, responseHeader http.Header) Codec {
+	for _, subprotocol := range websocket.Subprotocols(r) {
+		for _, codec := range m.config.Codecs {
+			if codec.Subprotocol() == subprotocol {
+				responseHeader.Set("Sec-WebSocket-Protocol", subprotocol)
+				return codec
+			}
+		}
+	}
+	return JSONCodec
+}
+
+// DefaultCodecs are the codecs a client can ask for unless anything else is configured
+func DefaultCodecs() []Codec {
+	return []Codec{JSONCodec, MessagePackCodec, CBORCodec, ProtobufCodec}
+}
+
+// JSONCodec sends events as JSON text frames, it is used by clients that do not ask for a subprotocol
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+// jsonEvent is how a Event looks in JSON, the payload is already encoded
+type jsonEvent struct {
+	Type     string            `json:"type"`
+	Payload  json.RawMessage   `json:"payload"`
+	ID       string            `json:"id,omitempty"`
+	ReplyTo  string            `json:"reply_to,omitempty"`
+	Seq      uint64            `json:"seq,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func (jsonCodec) Subprotocol() string                { return "json" }
+func (jsonCodec) MessageType() int                   { return websocket.TextMessage }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+func (jsonCodec) MarshalEvent(event Event, payload []byte) ([]byte, error) {
+	return json.Marshal(jsonEvent{Type: event.Type, Payload: payload, ID: event.ID, ReplyTo: event.ReplyTo, Seq: event.Seq, Metadata: event.Metadata})
+}
+
+func (jsonCodec) UnmarshalEvent(data []byte) (Event, []byte, error) {
+	var wire jsonEvent
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return Event{}, nil, err
+	}
+	return Event{Type: wire.Type, ID: wire.ID, ReplyTo: wire.ReplyTo, Seq: wire.Seq, Metadata: wire.Metadata}, wire.Payload, nil
+}
@@ -0,0 +1,103 @@
+package hub
+
+import (
+	"bytes"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+// The binary codecs use the json tags of the payloads, so the fields are named the same in every codec
+
+// MessagePackCodec sends events as MessagePack in binary frames, ask for it with the msgpack subprotocol
+var MessagePackCodec Codec = messagePackCodec{}
+
+type messagePackCodec struct{}
+
+// msgpackEvent is how a Event looks in MessagePack, the payload is already encoded
+type msgpackEvent struct {
+	Type     string             `msgpack:"type"`
+	Payload  msgpack.RawMessage `msgpack:"payload"`
+	ID       string             `msgpack:"id,omitempty"`
+	ReplyTo  string             `msgpack:"reply_to,omitempty"`
+	Seq      uint64             `msgpack:"seq,omitempty"`
+	Metadata map[string]string  `msgpack:"metadata,omitempty"`
+}
+
+func (messagePackCodec) Subprotocol() string { return "msgpack" }
+func (messagePackCodec) MessageType() int    { return websocket.BinaryMessage }
+
+func (messagePackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (messagePackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func (c messagePackCodec) MarshalEvent(event Event, payload []byte) ([]byte, error) {
+	// A empty raw message would write nothing at all, not even the nil
+	if payload == nil {
+		payload = []byte{msgpcode.Nil}
+	}
+	return c.Marshal(msgpackEvent{Type: event.Type, Payload: payload, ID: event.ID, ReplyTo: event.ReplyTo, Seq: event.Seq, Metadata: event.Metadata})
+}
+
+func (c messagePackCodec) UnmarshalEvent(data []byte) (Event, []byte, error) {
+	var wire msgpackEvent
+	if err := c.Unmarshal(data, &wire); err != nil {
+		return Event{}, nil, err
+	}
+	return Event{Type: wire.Type, ID: wire.ID, ReplyTo: wire.ReplyTo, Seq: wire.Seq, Metadata: wire.Metadata}, wire.Payload, nil
+}
+
+// CBORCodec sends events as CBOR in binary frames, ask for it with the cbor subprotocol
+var CBORCodec Codec = cborCodec{}
+
+type cborCodec struct{}
+
+var (
+	// Times are sent as RFC 3339 strings like the JSON codec does, the default drops the fractions
+	cborEncMode, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	// Maps decoded into a any get string keys, so they can be encoded by the other codecs
+	cborDecMode, _ = cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]any(nil))}.DecMode()
+)
+
+// cborEvent is how a Event looks in CBOR, the payload is already encoded
+type cborEvent struct {
+	Type     string            `cbor:"type"`
+	Payload  cbor.RawMessage   `cbor:"payload"`
+	ID       string            `cbor:"id,omitempty"`
+	ReplyTo  string            `cbor:"reply_to,omitempty"`
+	Seq      uint64            `cbor:"seq,omitempty"`
+	Metadata map[string]string `cbor:"metadata,omitempty"`
+}
+
+func (cborCodec) Subprotocol() string                { return "cbor" }
+func (cborCodec) MessageType() int                   { return websocket.BinaryMessage }
+func (cborCodec) Marshal(v any) ([]byte, error)      { return cborEncMode.Marshal(v) }
+func (cborCodec) Unmarshal(data []byte, v any) error { return cborDecMode.Unmarshal(data, v) }
+
+func (cborCodec) MarshalEvent(event Event, payload []byte) ([]byte, error) {
+	return cborEncMode.Marshal(cborEvent{Type: event.Type, Payload: payload, ID: event.ID, ReplyTo: event.ReplyTo, Seq: event.Seq, Metadata: event.Metadata})
+}
+
+func (cborCodec) UnmarshalEvent(data []byte) (Event, []byte, error) {
+	var wire cborEvent
+	if err := cborDecMode.Unmarshal(data, &wire); err != nil {
+		return Event{}, nil, err
+	}
+	return Event{Type: wire.Type, ID: wire.ID, ReplyTo: wire.ReplyTo, Seq: wire.Seq, Metadata: wire.Metadata}, wire.Payload, nil
+}
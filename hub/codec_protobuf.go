@@ -0,0 +1,135 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"programmingpercy.tech/websockets-go/hub/pb"
+)
+
+// ProtobufCodec sends events as protobuf in binary frames, ask for it with the protobuf subprotocol.
+// The schemas are in pb/events.proto. Payloads with a schema are sent as their message, the rest
+// as a google.protobuf.Struct with the same fields as the JSON payload. Handlers decode into the
+// same Go types whatever the wire format is
+var ProtobufCodec Codec = protobufCodec{}
+
+// errUntypedProtobuf is returned when a protobuf payload is decoded without knowing its message
+var errUntypedProtobuf = errors.New("protobuf payloads can only be decoded into a known type")
+
+type protobufCodec struct{}
+
+func (protobufCodec) Subprotocol() string { return "protobuf" }
+func (protobufCodec) MessageType() int    { return websocket.BinaryMessage }
+
+// Marshal encodes the payloads that have a schema as their message, anything else as a Struct
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	var msg proto.Message
+	switch v := v.(type) {
+	case proto.Message:
+		msg = v
+	case SendMessageEvent:
+		msg = &pb.SendMessageEvent{Message: v.Message, From: v.From}
+	case NewMessageEvent:
+		msg = &pb.NewMessageEvent{Message: v.Message, From: v.From, Sent: timestamppb.New(v.Sent)}
+	case HistoryMessage:
+		msg = &pb.NewMessageEvent{Message: v.Message, From: v.From, Sent: timestamppb.New(v.Sent), Id: v.ID}
+	case ChangeRoomEvent:
+		msg = &pb.ChangeRoomEvent{Name: v.Name}
+	default:
+		// Go through JSON so the fields are named by the json tags
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, errors.New("protobuf payloads without a schema have to be objects")
+		}
+		if msg, err = structpb.NewStruct(fields); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	// A message of only default values is empty, it is still a payload
+	if data == nil {
+		data = []byte{}
+	}
+	return data, nil
+}
+
+// Unmarshal decodes into the Go types that have a schema, anything else is read as a Struct
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	switch v := v.(type) {
+	case proto.Message:
+		return proto.Unmarshal(data, v)
+	case *SendMessageEvent:
+		var msg pb.SendMessageEvent
+		if err := proto.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		*v = SendMessageEvent{Message: msg.Message, From: msg.From}
+	case *NewMessageEvent:
+		var msg pb.NewMessageEvent
+		if err := proto.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		*v = newMessageFromProto(&msg)
+	case *HistoryMessage:
+		var msg pb.NewMessageEvent
+		if err := proto.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		*v = HistoryMessage{ID: msg.Id, NewMessageEvent: newMessageFromProto(&msg)}
+	case *ChangeRoomEvent:
+		var msg pb.ChangeRoomEvent
+		if err := proto.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		*v = ChangeRoomEvent{Name: msg.Name}
+	case *any:
+		// Without a type the bytes could be any message, a typed one would be read as garbage
+		return errUntypedProtobuf
+	default:
+		var fields structpb.Struct
+		if err := proto.Unmarshal(data, &fields); err != nil {
+			return err
+		}
+		data, err := json.Marshal(fields.AsMap())
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, v)
+	}
+	return nil
+}
+
+// newMessageFromProto converts the message into the Go type handlers use
+func newMessageFromProto(msg *pb.NewMessageEvent) NewMessageEvent {
+	var event NewMessageEvent
+	event.Message = msg.Message
+	event.From = msg.From
+	if msg.Sent != nil {
+		event.Sent = msg.Sent.AsTime()
+	}
+	return event
+}
+
+func (c protobufCodec) MarshalEvent(event Event, payload []byte) ([]byte, error) {
+	return proto.Marshal(&pb.Event{Type: event.Type, Payload: payload, Id: event.ID, ReplyTo: event.ReplyTo, Seq: event.Seq, Metadata: event.Metadata})
+}
+
+func (c protobufCodec) UnmarshalEvent(data []byte) (Event, []byte, error) {
+	var wire pb.Event
+	if err := proto.Unmarshal(data, &wire); err != nil {
+		return Event{}, nil, err
+	}
+	return Event{Type: wire.Type, ID: wire.Id, ReplyTo: wire.ReplyTo, Seq: wire.Seq, Metadata: wire.Metadata}, wire.Payload, nil
+}
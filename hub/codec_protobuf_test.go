@@ -0,0 +1,101 @@
+package hub
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+	"programmingpercy.tech/websockets-go/hub/pb"
+)
+
+func TestProtobufCodec_Schemas(t *testing.T) {
+	data, err := ProtobufCodec.Marshal(SendMessageEvent{Message: "hello", From: "percy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The payload is the generated message, so other protobuf clients can read it with the schema
+	var msg pb.SendMessageEvent
+	if err := proto.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Message != "hello" || msg.From != "percy" {
+		t.Errorf("unexpected message %v", &msg)
+	}
+
+	// Types without a schema are sent as a Struct
+	data, err = ProtobufCodec.Marshal(ErrorEvent{Code: ErrCodeBadRequest, Message: "oops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed ErrorEvent
+	if err := ProtobufCodec.Unmarshal(data, &failed); err != nil {
+		t.Fatal(err)
+	}
+	if failed.Code != ErrCodeBadRequest || failed.Message != "oops" {
+		t.Errorf("unexpected error %+v", failed)
+	}
+}
+
+func TestManager_ProtobufHandlerInput(t *testing.T) {
+	m, srv := newTestServer(t)
+	received := make(chan any, 1)
+	capture := func(decoded func() any) EventHandler {
+		return func(event Event, c *Client) error {
+			v := decoded()
+			if err := event.Payload.Decode(v); err != nil {
+				return err
+			}
+			received <- reflect.ValueOf(v).Elem().Interface()
+			return nil
+		}
+	}
+	m.RegisterHandler(EventSendMessage, capture(func() any { return new(SendMessageEvent) }))
+	m.RegisterHandler(EventChangeRoom, capture(func() any { return new(ChangeRoomEvent) }))
+	m.RegisterHandler(EventSendDirect, capture(func() any { return new(SendDirectEvent) }))
+
+	jsonConn := connect(t, srv, "percy")
+	protobufConn := dialCodec(t, srv, ProtobufCodec.Subprotocol())
+
+	payloads := []struct {
+		eventType string
+		payload   any
+	}{
+		{EventSendMessage, SendMessageEvent{Message: "hello", From: "percy"}},
+		{EventChangeRoom, ChangeRoomEvent{Name: "games"}},
+		// Only default values, protobuf sends a empty payload
+		{EventChangeRoom, ChangeRoomEvent{}},
+		// No schema, sent as a Struct
+		{EventSendDirect, SendDirectEvent{To: "anna", Message: "psst"}},
+	}
+	for _, p := range payloads {
+		sendEvent(t, jsonConn, p.eventType, p.payload)
+		fromJSON := receive(t, received)
+
+		data, err := marshalEvent(ProtobufCodec, Event{Type: p.eventType, Payload: NewPayload(p.payload)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := protobufConn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			t.Fatal(err)
+		}
+		fromProtobuf := receive(t, received)
+
+		if !reflect.DeepEqual(fromJSON, fromProtobuf) || !reflect.DeepEqual(fromJSON, p.payload) {
+			t.Errorf("%s: json gave %+v, protobuf gave %+v", p.eventType, fromJSON, fromProtobuf)
+		}
+	}
+}
+
+// receive waits for a handler to decode a payload
+func receive(t *testing.T, received chan any) any {
+	t.Helper()
+	select {
+	case v := <-received:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the handler")
+		return nil
+	}
+}
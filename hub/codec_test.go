@@ -0,0 +1,148 @@
+package hub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	sent := time.Now()
+	var msg HistoryMessage
+	msg.ID = 7
+	msg.Message = "hello"
+	msg.From = "percy"
+	msg.Sent = sent
+
+	for _, codec := range DefaultCodecs() {
+		t.Run(codec.Subprotocol(), func(t *testing.T) {
+			metadata := map[string]string{"traceparent": "00-" + clientTrace + "-" + clientSpan + "-01"}
+			data, err := marshalEvent(codec, Event{Type: EventNewMessage, Payload: NewPayload(msg), ID: "1", ReplyTo: "2", Seq: 3, Metadata: metadata})
+			if err != nil {
+				t.Fatal(err)
+			}
+			event, err := unmarshalEvent(codec, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.Type != EventNewMessage || event.ID != "1" || event.ReplyTo != "2" || event.Seq != 3 ||
+				event.Metadata["traceparent"] != metadata["traceparent"] {
+				t.Errorf("unexpected event %+v", event)
+			}
+
+			var decoded HistoryMessage
+			if err := event.Payload.Decode(&decoded); err != nil {
+				t.Fatal(err)
+			}
+			if decoded.ID != 7 || decoded.Message != "hello" || decoded.From != "percy" || !decoded.Sent.Equal(sent) {
+				t.Errorf("expected %+v, got %+v", msg, decoded)
+			}
+
+			// Every other codec can encode the payload as it was received. It is forwarded without knowing
+			// its type, so only the generic values are compared, a time may arrive as a string.
+			// Protobuf needs the type to read a payload, so it can not be forwarded blindly
+			if codec == ProtobufCodec {
+				return
+			}
+			for _, other := range DefaultCodecs() {
+				data, err := marshalEvent(other, event)
+				if err != nil {
+					t.Fatalf("failed to forward to %s: %v", other.Subprotocol(), err)
+				}
+				forwarded, err := unmarshalEvent(other, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got map[string]any
+				if err := forwarded.Payload.Decode(&got); err != nil || got["message"] != "hello" {
+					t.Errorf("forwarded to %s got %+v %v", other.Subprotocol(), got, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCodecs_EmptyPayload(t *testing.T) {
+	for _, codec := range DefaultCodecs() {
+		data, err := marshalEvent(codec, Event{Type: EventTypingStart})
+		if err != nil {
+			t.Fatal(err)
+		}
+		event, err := unmarshalEvent(codec, data)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.Subprotocol(), err)
+		}
+		if event.Type != EventTypingStart {
+			t.Errorf("%s: unexpected event %+v", codec.Subprotocol(), event)
+		}
+	}
+}
+
+// dialCodec connects as percy asking for the subprotocol
+func dialCodec(t *testing.T, srv *httptest.Server, subprotocol string) *websocket.Conn {
+	t.Helper()
+	otp, status := login(t, srv, "percy", "123")
+	if status != http.StatusOK {
+		t.Fatalf("failed to login: %d", status)
+	}
+	dialer := websocket.Dialer{Subprotocols: []string{"made-up", subprotocol}}
+	header := http.Header{}
+	header.Set("Origin", "https://localhost:8080")
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?otp="+otp, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if conn.Subprotocol() != subprotocol {
+		t.Fatalf("expected the server to pick %s, got %q", subprotocol, conn.Subprotocol())
+	}
+	return conn
+}
+
+func TestManager_BinaryCodecs(t *testing.T) {
+	_, srv := newTestServer(t)
+
+	for _, codec := range []Codec{MessagePackCodec, CBORCodec, ProtobufCodec} {
+		t.Run(codec.Subprotocol(), func(t *testing.T) {
+			conn := dialCodec(t, srv, codec.Subprotocol())
+
+			data, err := marshalEvent(codec, Event{Type: EventSendMessage, Payload: NewPayload(SendMessageEvent{Message: "binary"})})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				t.Fatal(err)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			for {
+				messageType, data, err := conn.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if messageType != websocket.BinaryMessage {
+					t.Fatalf("expected a binary frame, got %d", messageType)
+				}
+				event, err := unmarshalEvent(codec, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if event.Type != EventNewMessage {
+					continue
+				}
+				var msg HistoryMessage
+				if err := event.Payload.Decode(&msg); err != nil {
+					t.Fatal(err)
+				}
+				if msg.Message != "binary" || msg.From != "percy" || msg.ID == 0 {
+					t.Errorf("unexpected message %+v", msg)
+				}
+				return
+			}
+		})
+	}
+}
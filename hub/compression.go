@@ -0,0 +1,214 @@
+package hub
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// CompressionConfig configures permessage-deflate, the websocket extension that compresses each message.
+// It is only used with clients that ask for it, every browser does
+type CompressionConfig struct {
+	// Enabled offers compression to clients
+	Enabled bool
+	// Level is the compress/flate level, from -2 (huffman only) to 9 (best compression)
+	Level int
+	// Threshold is the size in bytes below which messages are sent uncompressed,
+	// small messages cost more CPU than they save and can even grow
+	Threshold int
+}
+
+// DefaultCompressionConfig is used by the Manager unless anything else is configured
+var DefaultCompressionConfig = CompressionConfig{
+	Enabled:   false,
+	Level:     flate.BestSpeed,
+	Threshold: 256,
+}
+
+// CompressionStats are counters of how much the messages written to clients has been compressed
+type CompressionStats struct {
+	// Messages is the number of messages written
+	Messages int64 `json:"messages"`
+	// Compressed is how many of them was sent compressed
+	Compressed int64 `json:"compressed"`
+	// BytesBefore is the size of the messages as encoded by the codec
+	BytesBefore int64 `json:"bytes_before"`
+	// BytesAfter is what was written to the network for them, websocket frame headers included
+	BytesAfter int64 `json:"bytes_after"`
+}
+
+// compressionMetrics holds the counters behind CompressionStats, only use it with sync/atomic
+type compressionMetrics struct {
+	messages    int64
+	compressed  int64
+	bytesBefore int64
+	bytesAfter  int64
+}
+
+func (cm *compressionMetrics) add(before, after int64, compressed bool) {
+	atomic.AddInt64(&cm.messages, 1)
+	if compressed {
+		atomic.AddInt64(&cm.compressed, 1)
+	}
+	atomic.AddInt64(&cm.bytesBefore, before)
+	atomic.AddInt64(&cm.bytesAfter, after)
+}
+
+func (cm *compressionMetrics) snapshot() CompressionStats {
+	return CompressionStats{
+		Messages:    atomic.LoadInt64(&cm.messages),
+		Compressed:  atomic.LoadInt64(&cm.compressed),
+		BytesBefore: atomic.LoadInt64(&cm.bytesBefore),
+		BytesAfter:  atomic.LoadInt64(&cm.bytesAfter),
+	}
+}
+
+// CompressionStats returns how much the messages written to the client has been compressed
+func (c *Client) CompressionStats() CompressionStats {
+	return c.compressionMetrics.snapshot()
+}
+
+// writeFrame writes a message, compressing it if it is large enough, and counts the bytes before and after
+func (c *Client) writeFrame(messageType int, data []byte) error {
+	compress := c.compress && len(data) >= c.manager.config.Compression.Threshold
+	c.connection.EnableWriteCompression(compress)
+	// Clients created outside ServeWS have no counted connection
+	if c.wire == nil {
+		return c.connection.WriteMessage(messageType, data)
+	}
+
+	written := c.wire.written()
+	if err := c.connection.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+	before, after := int64(len(data)), c.wire.written()-written
+	c.compressionMetrics.add(before, after, compress)
+	c.manager.compressionMetrics.add(before, after, compress)
+	return nil
+}
+
+// offersCompression reports if the client asks for permessage-deflate, the upgrader agrees to it
+// whenever compression is enabled
+func offersCompression(r *http.Request) bool {
+	for _, header := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, extension := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(extension, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// countingConn counts the bytes of the data frames written to the network. Control frames, like the
+// pongs the reader answers pings with, are left out so they do not end up in the size of a message
+type countingConn struct {
+	net.Conn
+	// count is the bytes of data frames written, only use it with sync/atomic
+	count int64
+	// framed is set once the handshake is written, what follows is websocket frames
+	framed bool
+	// The frame being written. The websocket.Conn never writes concurrently, so no lock is needed
+	header    []byte
+	remaining int64
+	control   bool
+}
+
+func (cc *countingConn) Write(p []byte) (int, error) {
+	n, err := cc.Conn.Write(p)
+	if cc.framed {
+		cc.countFrames(p[:n])
+	}
+	return n, err
+}
+
+// countFrames follows the frame headers in p and counts the bytes that belong to data frames
+func (cc *countingConn) countFrames(p []byte) {
+	var data int64
+	for len(p) > 0 {
+		if cc.remaining == 0 {
+			// A new frame, its header can be split over writes
+			cc.header = append(cc.header, p[0])
+			p = p[1:]
+			payload, ok := frameHeader(cc.header)
+			if !ok {
+				continue
+			}
+			// Opcodes from 8 are close, ping and pong
+			cc.control = cc.header[0]&0x0f >= 8
+			if !cc.control {
+				data += int64(len(cc.header))
+			}
+			cc.header = cc.header[:0]
+			cc.remaining = payload
+			continue
+		}
+		n := min(int64(len(p)), cc.remaining)
+		if !cc.control {
+			data += n
+		}
+		cc.remaining -= n
+		p = p[n:]
+	}
+	atomic.AddInt64(&cc.count, data)
+}
+
+func (cc *countingConn) written() int64 {
+	return atomic.LoadInt64(&cc.count)
+}
+
+// frameHeader returns the payload length of a websocket frame header, ok is false until the header is complete
+func frameHeader(header []byte) (payload int64, ok bool) {
+	if len(header) < 2 {
+		return 0, false
+	}
+	length := header[1] & 0x7f
+	size := 2
+	switch length {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if header[1]&0x80 != 0 {
+		// Masked, servers never do this but the key is part of the header
+		size += 4
+	}
+	if len(header) < size {
+		return 0, false
+	}
+
+	switch length {
+	case 126:
+		return int64(binary.BigEndian.Uint16(header[2:4])), true
+	case 127:
+		return int64(binary.BigEndian.Uint64(header[2:10])), true
+	default:
+		return int64(length), true
+	}
+}
+
+// countingResponseWriter hands the upgrader a countingConn when it hijacks the connection
+type countingResponseWriter struct {
+	http.ResponseWriter
+	conn *countingConn
+}
+
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.conn = &countingConn{Conn: conn}
+	return w.conn, brw, nil
+}
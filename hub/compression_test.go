@@ -0,0 +1,140 @@
+package hub
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestOffersCompression(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                   false,
+		"permessage-deflate": true,
+		"x-webkit-deflate-frame, permessage-deflate; client_max_window_bits": true,
+		"permessage-deflate-ish": false,
+	} {
+		r, _ := http.NewRequest(http.MethodGet, "/ws", nil)
+		if header != "" {
+			r.Header.Set("Sec-WebSocket-Extensions", header)
+		}
+		if got := offersCompression(r); got != want {
+			t.Errorf("%q: expected %v, got %v", header, want, got)
+		}
+	}
+}
+
+// clientOf returns the connected client of username
+func clientOf(t *testing.T, m *Manager, username string) *Client {
+	t.Helper()
+	m.RLock()
+	defer m.RUnlock()
+	for client := range m.clients {
+		if client.identity.Username == username {
+			return client
+		}
+	}
+	t.Fatalf("%s is not connected", username)
+	return nil
+}
+
+func TestManager_Compression(t *testing.T) {
+	m, srv := newTestServer(t, WithCompression(9, 200))
+
+	otp, _ := login(t, srv, "percy", "123")
+	dialer := websocket.Dialer{EnableCompression: true}
+	header := http.Header{}
+	header.Set("Origin", "https://localhost:8080")
+	percy, resp, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?otp="+otp, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { percy.Close() })
+	if !strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+		t.Fatal("expected the server to agree to permessage-deflate")
+	}
+	// anna does not ask for compression
+	anna := connect(t, srv, "anna")
+	waitForClients(t, m, 2)
+
+	long := strings.Repeat("compress me ", 30)
+	sendEvent(t, percy, EventSendMessage, SendMessageEvent{Message: long})
+	for _, conn := range []*websocket.Conn{percy, anna} {
+		var msg NewMessageEvent
+		readUntil(t, conn, EventNewMessage, &msg)
+		if msg.Message != long {
+			t.Errorf("expected the long message, got %q", msg.Message)
+		}
+	}
+
+	compressed := clientOf(t, m, "percy").CompressionStats()
+	// The presence snapshot is below the threshold, the long message is not
+	if compressed.Compressed != 1 || compressed.Messages < 2 {
+		t.Errorf("expected only the long message to be compressed, got %+v", compressed)
+	}
+	if compressed.BytesAfter >= compressed.BytesBefore {
+		t.Errorf("expected compression to save bytes, got %+v", compressed)
+	}
+
+	plain := clientOf(t, m, "anna").CompressionStats()
+	if plain.Compressed != 0 || plain.BytesAfter <= plain.BytesBefore {
+		t.Errorf("expected no compression, got %+v", plain)
+	}
+
+	// The admin API shows the same per connection
+	for _, info := range m.Clients() {
+		if info.User == "percy" && info.Compression != clientOf(t, m, "percy").CompressionStats() {
+			t.Errorf("expected the stats of percy in the client info, got %+v", info.Compression)
+		}
+	}
+
+	// And the metrics, by the same conn id
+	percyClient := clientOf(t, m, "percy")
+	want := fmt.Sprintf("websockets_connection_compression_bytes_after_total{conn=%q} %d\n", percyClient.id, percyClient.CompressionStats().BytesAfter)
+	if metrics := scrape(t, m); !strings.Contains(metrics, want) {
+		t.Errorf("expected %q in\n%s", want, metrics)
+	}
+
+	total := m.CompressionStats()
+	if total.Messages != compressed.Messages+plain.Messages || total.BytesAfter != compressed.BytesAfter+plain.BytesAfter {
+		t.Errorf("expected the manager to count both clients, got %+v", total)
+	}
+}
+
+func TestCountingConn_CountsDataFrames(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, client)
+	cc := &countingConn{Conn: server}
+
+	payload := bytes.Repeat([]byte("x"), 256)
+	writes := [][]byte{
+		// The handshake is not a frame
+		[]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"),
+		// A text frame with a 16 bit length, its header split over two writes
+		{0x81},
+		append([]byte{126, 0x01, 0x00}, payload[:100]...),
+		payload[100:],
+		// A pong written by the reader in between
+		{0x8a, 2, 'h', 'i'},
+		// A binary frame and a close frame in one write
+		{0x82, 3, 1, 2, 3, 0x88, 2, 0x03, 0xe8},
+	}
+	for i, data := range writes {
+		if _, err := cc.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			cc.framed = true
+		}
+	}
+
+	if want := int64(4 + 256 + 2 + 3); cc.written() != want {
+		t.Errorf("expected %d bytes of data frames, got %d", want, cc.written())
+	}
+}
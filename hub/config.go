@@ -0,0 +1,503 @@
+package hub
+
+import (
+	"compress/flate"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the hub and the server running it.
+// Start from DefaultConfig and override values from a file, the environment and flags using LoadConfig,
+// or pass single values to NewManager with the With options
+type Config struct {
+	// Addr is the address the server listens on, only used by the binary
+	Addr string
+	// MetricsAddr is the address the binary serves /metrics on over plain HTTP, apart from the public listener.
+	// Leave it empty to not serve metrics
+	MetricsAddr string
+	// CertFile and KeyFile are the TLS certificate and key, only used by the binary
+	CertFile string
+	KeyFile  string
+	// ShutdownTimeout is how long the binary waits for clients to drain when stopping
+	ShutdownTimeout time.Duration
+	// LogLevel is the lowest level the binary logs, debug logs every frame
+	LogLevel slog.Level
+	// OTLPEndpoint is where the binary exports spans to over OTLP/HTTP, like http://localhost:4318.
+	// Leave it empty to not trace
+	OTLPEndpoint string
+
+	// AllowedOrigins are the origins allowed to open a websocket, * allows any origin
+	AllowedOrigins []string
+	// ReadBufferSize and WriteBufferSize are the websocket IO buffer sizes in bytes
+	ReadBufferSize  int
+	WriteBufferSize int
+	// ReadLimit is the max size in bytes of a message sent by a client
+	ReadLimit int64
+	// PongWait is how long we will await a pong response from client
+	PongWait time.Duration
+	// PingInterval is how often pings are sent, it has to be less than PongWait.
+	// Leave it as 0 to use 90% of PongWait
+	PingInterval time.Duration
+
+	// OTPTTL is how long a OTP handed out by the login is valid
+	OTPTTL time.Duration
+	// RPCTimeout is how long a RPC handler may run
+	RPCTimeout time.Duration
+	// TypingTimeout is how long a typing indicator lasts without a typing_stop
+	TypingTimeout time.Duration
+	// TypingThrottle is how often a client's typing_start is fanned out
+	TypingThrottle time.Duration
+	// SessionTTL is how long a session can be resumed after its connection is lost
+	SessionTTL time.Duration
+	// SessionBufferSize is how many of the latest events a session keeps for a resume
+	SessionBufferSize int
+	// Egress configures the outbound queue of every client
+	Egress EgressConfig
+	// Compression configures permessage-deflate of the messages sent to clients
+	Compression CompressionConfig
+	// RateLimit limits the events each user can send over all its connections
+	RateLimit RateLimitConfig
+
+	// History stores the messages of every room, it can only be set from code.
+	// Leave it nil to keep the latest DefaultHistoryCapacity messages of up to DefaultHistoryRooms rooms in memory
+	History HistoryStore
+	// HistoryLimit is how many messages are sent when joining a room, and the max page size of load_history
+	HistoryLimit int
+	// RoomStore remembers the rooms and what room each user is in, it can only be set from code.
+	// Leave it nil to keep them in memory
+	RoomStore RoomStore
+	// DirectHistory stores the direct messages of every conversation, it can only be set from code.
+	// It is kept apart from History so conversations never show up as rooms.
+	// Leave it nil to keep the latest DefaultHistoryCapacity messages of up to DefaultHistoryRooms conversations in memory
+	DirectHistory HistoryStore
+	// Blocks remembers who blocked whom, it can only be set from code. Leave it nil to keep it in memory
+	Blocks BlockList
+	// Codecs are the encodings a client can ask for with Sec-WebSocket-Protocol, it can only be set from code.
+	// Leave it nil to offer DefaultCodecs, clients that ask for nothing always get JSONCodec
+	Codecs []Codec
+	// Logger is where the hub logs, it can only be set from code. Leave it nil to use slog.Default.
+	// Lines about a client carry its conn id, user, room and remote address, every frame is logged at debug
+	Logger *slog.Logger
+	// TracerProvider creates the spans of logins, upgrades, handled events and writes, it can only be set from code.
+	// Leave it nil to use the global provider of otel, which does nothing until one is set
+	TracerProvider trace.TracerProvider
+}
+
+// DefaultConfig returns the settings used when nothing else is configured
+func DefaultConfig() Config {
+	return Config{
+		Addr:            ":8080",
+		MetricsAddr:     "127.0.0.1:9464",
+		CertFile:        "server.crt",
+		KeyFile:         "server.key",
+		ShutdownTimeout: 10 * time.Second,
+		LogLevel:        slog.LevelInfo,
+		AllowedOrigins:  []string{"https://localhost:8080"},
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		ReadLimit:       512,
+		PongWait:        10 * time.Second,
+		OTPTTL:          5 * time.Second,
+		RPCTimeout:      DefaultRPCTimeout,
+		Egress:          DefaultEgressConfig,
+		Compression:     DefaultCompressionConfig,
+		RateLimit:       DefaultRateLimitConfig(),
+		HistoryLimit:    50,
+		// Long enough to ride out a flaky network or a laptop lid closing for a moment
+		SessionTTL:        2 * time.Minute,
+		SessionBufferSize: 256,
+		TypingTimeout:     5 * time.Second,
+		TypingThrottle:    time.Second,
+	}
+}
+
+// pingInterval returns the configured PingInterval or 90% of PongWait.
+// We cant multiply by 0.9 as that can make decimals, so instead *9 / 10
+func (cfg Config) pingInterval() time.Duration {
+	if cfg.PingInterval > 0 {
+		return cfg.PingInterval
+	}
+	return (cfg.PongWait * 9) / 10
+}
+
+// Validate makes sure the config can be used, it reports all problems at once
+func (cfg Config) Validate() error {
+	var problems []string
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, problem)
+		}
+	}
+
+	check(cfg.Addr != "", "addr can not be empty")
+	check(cfg.ShutdownTimeout > 0, "shutdown-timeout has to be positive")
+	check(len(cfg.AllowedOrigins) > 0, "allowed-origins can not be empty, use * to allow any origin")
+	check(cfg.ReadBufferSize > 0, "read-buffer-size has to be positive")
+	check(cfg.WriteBufferSize > 0, "write-buffer-size has to be positive")
+	check(cfg.ReadLimit > 0, "read-limit has to be positive")
+	check(cfg.PongWait > 0, "pong-wait has to be positive")
+	check(cfg.PingInterval >= 0, "ping-interval can not be negative")
+	// The reason why it has to be less than pongWait is becuase otherwise it will send a new Ping before getting response
+	check(cfg.pingInterval() < cfg.PongWait, "ping-interval has to be less than pong-wait")
+	check(cfg.OTPTTL > 0, "otp-ttl has to be positive")
+	check(cfg.RPCTimeout > 0, "rpc-timeout has to be positive")
+	check(cfg.TypingTimeout > 0, "typing-timeout has to be positive")
+	check(cfg.TypingThrottle >= 0, "typing-throttle can not be negative")
+	check(cfg.SessionTTL > 0, "session-ttl has to be positive")
+	check(cfg.SessionBufferSize > 0, "session-buffer-size has to be positive")
+	check(cfg.Egress.QueueSize > 0, "egress-queue-size has to be positive")
+	check(cfg.Egress.Policy >= DropOldest && cfg.Egress.Policy <= Block, "egress-policy is unknown")
+	check(cfg.Egress.Policy != Block || cfg.Egress.BlockTimeout > 0, "egress-block-timeout has to be positive when using the block policy")
+	check(cfg.HistoryLimit > 0, "history-limit has to be positive")
+	check(cfg.Egress.Policy != Disconnect || (cfg.Egress.CloseCode >= 1000 && cfg.Egress.CloseCode <= 4999), "egress-close-code has to be a websocket close code between 1000 and 4999 when using the disconnect policy")
+	check(cfg.Compression.Level >= flate.HuffmanOnly && cfg.Compression.Level <= flate.BestCompression, "compression-level has to be between -2 and 9")
+	check(cfg.Compression.Threshold >= 0, "compression-threshold can not be negative")
+	check(cfg.RateLimit.User.valid(), "rate-limit-user needs a positive rate and a burst of at least 1")
+	for _, eventType := range sortedKeys(cfg.RateLimit.Events) {
+		check(cfg.RateLimit.Events[eventType].valid(), "rate-limit-events of "+eventType+" needs a positive rate and a burst of at least 1")
+	}
+	check(cfg.RateLimit.Action >= RateLimitDrop && cfg.RateLimit.Action <= RateLimitDisconnect, "rate-limit-action is unknown")
+	check(cfg.RateLimit.Action != RateLimitMute || cfg.RateLimit.MuteDuration > 0, "rate-limit-mute has to be positive when using the mute action")
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// flagSet binds every setting of cfg to a flag. It is the single list of settings,
+// the file, environment and command line are all applied through it
+func (cfg *Config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "address to listen on")
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", cfg.MetricsAddr, "address to serve /metrics on over plain HTTP, keep it private, empty disables metrics")
+	fs.StringVar(&cfg.CertFile, "cert-file", cfg.CertFile, "TLS certificate file")
+	fs.StringVar(&cfg.KeyFile, "key-file", cfg.KeyFile, "TLS key file")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "how long to wait for clients to drain when stopping")
+	fs.TextVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "lowest level to log: debug, info, warn or error")
+	fs.StringVar(&cfg.OTLPEndpoint, "otlp-endpoint", cfg.OTLPEndpoint, "OTLP/HTTP endpoint to export spans to, like http://localhost:4318, empty disables tracing")
+	fs.Var((*stringList)(&cfg.AllowedOrigins), "allowed-origins", "comma separated origins allowed to connect, * allows any")
+	fs.IntVar(&cfg.ReadBufferSize, "read-buffer-size", cfg.ReadBufferSize, "websocket read buffer size in bytes")
+	fs.IntVar(&cfg.WriteBufferSize, "write-buffer-size", cfg.WriteBufferSize, "websocket write buffer size in bytes")
+	fs.Int64Var(&cfg.ReadLimit, "read-limit", cfg.ReadLimit, "max size of a client message in bytes")
+	fs.DurationVar(&cfg.PongWait, "pong-wait", cfg.PongWait, "how long to wait for a pong")
+	fs.DurationVar(&cfg.PingInterval, "ping-interval", cfg.PingInterval, "how often to ping clients, 0 uses 90% of pong-wait")
+	fs.DurationVar(&cfg.OTPTTL, "otp-ttl", cfg.OTPTTL, "how long a login OTP is valid")
+	fs.DurationVar(&cfg.RPCTimeout, "rpc-timeout", cfg.RPCTimeout, "how long a RPC handler may run")
+	fs.DurationVar(&cfg.TypingTimeout, "typing-timeout", cfg.TypingTimeout, "how long a typing indicator lasts without a typing_stop")
+	fs.DurationVar(&cfg.TypingThrottle, "typing-throttle", cfg.TypingThrottle, "how often typing_start of a client is fanned out")
+	fs.DurationVar(&cfg.SessionTTL, "session-ttl", cfg.SessionTTL, "how long a session can be resumed after the connection is lost")
+	fs.IntVar(&cfg.SessionBufferSize, "session-buffer-size", cfg.SessionBufferSize, "events kept per session for a resume")
+	fs.IntVar(&cfg.Egress.QueueSize, "egress-queue-size", cfg.Egress.QueueSize, "events queued per client before the egress policy applies")
+	fs.Var(&cfg.Egress.Policy, "egress-policy", "what to do when a client queue is full: drop_oldest, drop_newest, disconnect or block")
+	fs.DurationVar(&cfg.Egress.BlockTimeout, "egress-block-timeout", cfg.Egress.BlockTimeout, "how long the block policy waits for room")
+	fs.IntVar(&cfg.Egress.CloseCode, "egress-close-code", cfg.Egress.CloseCode, "close code sent to clients disconnected for being slow")
+	fs.BoolVar(&cfg.Compression.Enabled, "compression", cfg.Compression.Enabled, "compress messages with permessage-deflate for clients that ask for it")
+	fs.IntVar(&cfg.Compression.Level, "compression-level", cfg.Compression.Level, "flate compression level, -2 for huffman only up to 9 for best compression")
+	fs.IntVar(&cfg.Compression.Threshold, "compression-threshold", cfg.Compression.Threshold, "messages smaller than this many bytes are sent uncompressed")
+	fs.Var(&cfg.RateLimit.User, "rate-limit-user", "rate:burst of the events a user can send over all its connections, rate is per second, 0 is unlimited")
+	fs.Var((*eventRateLimits)(&cfg.RateLimit.Events), "rate-limit-events", "comma separated type=rate:burst limits of the events a user can send by type")
+	fs.Var(&cfg.RateLimit.Action, "rate-limit-action", "what to do with a event over the limit: drop, mute or disconnect")
+	fs.DurationVar(&cfg.RateLimit.MuteDuration, "rate-limit-mute", cfg.RateLimit.MuteDuration, "how long the mute action drops the events of the user")
+	fs.IntVar(&cfg.HistoryLimit, "history-limit", cfg.HistoryLimit, "messages sent when joining a room and max page size of load_history")
+	return fs
+}
+
+// RegisterFlags adds a flag for every setting to fs, parsing fs updates cfg
+func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
+	cfg.flagSet().VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+}
+
+// ApplyEnv overrides settings from environment variables, named as the flag in upper
+// case with - replaced by _ and prefix added, WS_ gives WS_PONG_WAIT for pong-wait
+func (cfg *Config) ApplyEnv(prefix string) error {
+	var err error
+	cfg.flagSet().VisitAll(func(f *flag.Flag) {
+		name := prefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, ok := os.LookupEnv(name)
+		if !ok || err != nil {
+			return
+		}
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("bad value for %s: %w", name, setErr)
+		}
+	})
+	return err
+}
+
+// LoadFile overrides settings from a JSON, YAML or TOML file picked by the extension.
+// Keys are the flag names, with - or _, all keys are optional but unknown keys are an error
+//
+//	pong_wait: 10s
+//	allowed_origins: [https://localhost:8080]
+func (cfg *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	values := make(map[string]any)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("unsupported config format %q, use .json, .yaml or .toml", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	fs := cfg.flagSet()
+	// Sort the keys so errors are reported in the same order every time
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		f := fs.Lookup(strings.ReplaceAll(key, "_", "-"))
+		if f == nil {
+			return fmt.Errorf("unknown setting %q in %s", key, path)
+		}
+		if err := f.Value.Set(configString(values[key])); err != nil {
+			return fmt.Errorf("bad value for %q in %s: %w", key, path, err)
+		}
+	}
+	return nil
+}
+
+// configString turns a decoded file value into the string form the flags parse
+func configString(value any) string {
+	switch v := value.(type) {
+	case []any:
+		parts := make([]string, len(v))
+		for i, part := range v {
+			parts[i] = configString(part)
+		}
+		return strings.Join(parts, ",")
+	case float64:
+		// JSON numbers, print integers without a exponent
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// LoadConfig builds the config from the defaults, then the file given by -config,
+// then environment variables with envPrefix, and last the command line flags in args.
+// fs may already have flags of its own, the config flags are added to it before parsing
+func LoadConfig(fs *flag.FlagSet, args []string, envPrefix string) (Config, error) {
+	// The flags are parsed into a throw away config first, as the file they point
+	// to and the environment has to be applied before the flags
+	parsed := DefaultConfig()
+	parsed.RegisterFlags(fs)
+	configPath := fs.String("config", "", "path to a .json, .yaml or .toml config file")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg := DefaultConfig()
+	if *configPath != "" {
+		if err := cfg.LoadFile(*configPath); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := cfg.ApplyEnv(envPrefix); err != nil {
+		return Config{}, err
+	}
+
+	// Apply only the flags that was actually set on the command line
+	final := cfg.flagSet()
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if target := final.Lookup(f.Name); target != nil && err == nil {
+			err = target.Value.Set(f.Value.String())
+		}
+	})
+	if err != nil {
+		return Config{}, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// stringList is a flag.Value of comma separated strings
+type stringList []string
+
+func (s *stringList) String() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	var list []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			list = append(list, part)
+		}
+	}
+	*s = list
+	return nil
+}
+
+// Option changes the Config used by NewManager
+type Option func(cfg *Config)
+
+// WithConfig replaces the whole config, options after it can still override single values
+func WithConfig(config Config) Option {
+	return func(cfg *Config) {
+		*cfg = config
+	}
+}
+
+// WithAllowedOrigins sets the origins allowed to open a websocket
+func WithAllowedOrigins(origins ...string) Option {
+	return func(cfg *Config) {
+		cfg.AllowedOrigins = origins
+	}
+}
+
+// WithBufferSizes sets the websocket read and write buffer sizes
+func WithBufferSizes(read, write int) Option {
+	return func(cfg *Config) {
+		cfg.ReadBufferSize = read
+		cfg.WriteBufferSize = write
+	}
+}
+
+// WithReadLimit sets the max size of a message sent by a client
+func WithReadLimit(limit int64) Option {
+	return func(cfg *Config) {
+		cfg.ReadLimit = limit
+	}
+}
+
+// WithPongWait sets how long to wait for a pong, and how often to ping
+func WithPongWait(pongWait, pingInterval time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.PongWait = pongWait
+		cfg.PingInterval = pingInterval
+	}
+}
+
+// WithOTPTTL sets how long a login OTP is valid
+func WithOTPTTL(ttl time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.OTPTTL = ttl
+	}
+}
+
+// WithRPCTimeout sets how long a RPC handler may run
+func WithRPCTimeout(timeout time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.RPCTimeout = timeout
+	}
+}
+
+// WithEgress configures the outbound queue of every client
+func WithEgress(egress EgressConfig) Option {
+	return func(cfg *Config) {
+		cfg.Egress = egress
+	}
+}
+
+// WithCompression enables permessage-deflate at level for messages of at least threshold bytes
+func WithCompression(level, threshold int) Option {
+	return func(cfg *Config) {
+		cfg.Compression = CompressionConfig{Enabled: true, Level: level, Threshold: threshold}
+	}
+}
+
+// WithRateLimit sets the limits of the events each user can send, and what happens when they are exceeded
+func WithRateLimit(limits RateLimitConfig) Option {
+	return func(cfg *Config) {
+		cfg.RateLimit = limits
+	}
+}
+
+// WithHistory sets where the messages of every room are stored, and how many are sent when joining a room
+func WithHistory(store HistoryStore, limit int) Option {
+	return func(cfg *Config) {
+		cfg.History = store
+		cfg.HistoryLimit = limit
+	}
+}
+
+// WithRoomStore sets where rooms and the room of each user are stored
+func WithRoomStore(store RoomStore) Option {
+	return func(cfg *Config) {
+		cfg.RoomStore = store
+	}
+}
+
+// WithSessions sets how long a lost session can be resumed and how many events it keeps
+func WithSessions(ttl time.Duration, bufferSize int) Option {
+	return func(cfg *Config) {
+		cfg.SessionTTL = ttl
+		cfg.SessionBufferSize = bufferSize
+	}
+}
+
+// WithTyping sets how long a typing indicator lasts and how often a typing_start is fanned out
+func WithTyping(timeout, throttle time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.TypingTimeout = timeout
+		cfg.TypingThrottle = throttle
+	}
+}
+
+// WithDirectHistory sets where the direct messages between users are stored
+func WithDirectHistory(store HistoryStore) Option {
+	return func(cfg *Config) {
+		cfg.DirectHistory = store
+	}
+}
+
+// WithBlockList sets where the users each user has blocked are stored
+func WithBlockList(blocks BlockList) Option {
+	return func(cfg *Config) {
+		cfg.Blocks = blocks
+	}
+}
+
+// WithLogger sets where the hub logs
+func WithLogger(logger *slog.Logger) Option {
+	return func(cfg *Config) {
+		cfg.Logger = logger
+	}
+}
+
+// WithTracerProvider sets what creates the spans of the hub
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(cfg *Config) {
+		cfg.TracerProvider = provider
+	}
+}
+
+// WithCodecs sets the encodings a client can ask for, clients that ask for nothing always get JSONCodec
+func WithCodecs(codecs ...Codec) Option {
+	return func(cfg *Config) {
+		cfg.Codecs = codecs
+	}
+}
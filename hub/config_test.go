@@ -0,0 +1,197 @@
+package hub
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConfig_LoadFile(t *testing.T) {
+	expected := DefaultConfig()
+	expected.Addr = ":9090"
+	expected.AllowedOrigins = []string{"https://chat.example.com", "https://localhost:9090"}
+	expected.ReadLimit = 4096
+	expected.PongWait = 30 * time.Second
+	expected.Egress.Policy = Disconnect
+	expected.Egress.QueueSize = 128
+
+	files := map[string]string{
+		"config.yaml": `
+addr: ":9090"
+allowed_origins: [https://chat.example.com, https://localhost:9090]
+read_limit: 4096
+pong-wait: 30s
+egress_policy: disconnect
+egress_queue_size: 128
+`,
+		"config.json": `{
+	"addr": ":9090",
+	"allowed_origins": ["https://chat.example.com", "https://localhost:9090"],
+	"read_limit": 4096,
+	"pong_wait": "30s",
+	"egress_policy": "disconnect",
+	"egress_queue_size": 128
+}`,
+		"config.toml": `
+addr = ":9090"
+allowed_origins = ["https://chat.example.com", "https://localhost:9090"]
+read_limit = 4096
+pong_wait = "30s"
+egress_policy = "disconnect"
+egress_queue_size = 128
+`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			if err := cfg.LoadFile(writeTempFile(t, name, content)); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cfg, expected) {
+				t.Errorf("expected %+v, got %+v", expected, cfg)
+			}
+		})
+	}
+}
+
+func TestConfig_LoadFileErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		file    string
+		content string
+		err     string
+	}{
+		{name: "unknown key", file: "config.yaml", content: "port: 8080", err: `unknown setting "port"`},
+		{name: "bad value", file: "config.yaml", content: "pong_wait: soon", err: `bad value for "pong_wait"`},
+		{name: "bad policy", file: "config.json", content: `{"egress_policy": "panic"}`, err: `unknown overflow policy "panic"`},
+		{name: "unsupported format", file: "config.ini", content: "addr=:80", err: "unsupported config format"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			err := cfg.LoadFile(writeTempFile(t, tc.file, tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	path := writeTempFile(t, "config.yaml", "addr: :1111\nread_limit: 1024\notp_ttl: 1m\n")
+	t.Setenv("TEST_READ_LIMIT", "2048")
+	t.Setenv("TEST_OTP_TTL", "2m")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	users := fs.String("users", "", "flags of the caller still works")
+	cfg, err := LoadConfig(fs, []string{"-config", path, "-otp-ttl", "3m", "-users", "users.json"}, "TEST_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// addr only comes from the file, read limit is overridden by the env and the otp ttl by the flag
+	if cfg.Addr != ":1111" || cfg.ReadLimit != 2048 || cfg.OTPTTL != 3*time.Minute {
+		t.Errorf("wrong precedence, got addr %s read limit %d otp ttl %s", cfg.Addr, cfg.ReadLimit, cfg.OTPTTL)
+	}
+	// Untouched values keep the default
+	if cfg.PongWait != DefaultConfig().PongWait {
+		t.Errorf("expected the default pong wait, got %s", cfg.PongWait)
+	}
+	if *users != "users.json" {
+		t.Errorf("expected the callers flag to be parsed, got %q", *users)
+	}
+}
+
+func TestLoadConfig_BadEnv(t *testing.T) {
+	t.Setenv("TEST_PONG_WAIT", "forever")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if _, err := LoadConfig(fs, nil, "TEST_"); err == nil || !strings.Contains(err.Error(), "TEST_PONG_WAIT") {
+		t.Errorf("expected the bad variable to be named, got %v", err)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("the default config should be valid: %v", err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.PongWait = time.Second
+	cfg.PingInterval = 2 * time.Second
+	cfg.AllowedOrigins = nil
+	cfg.Egress.Policy = Block
+	cfg.Egress.BlockTimeout = 0
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected the config to be invalid")
+	}
+	for _, problem := range []string{"ping-interval", "allowed-origins", "egress-block-timeout"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %s to be reported in %v", problem, err)
+		}
+	}
+}
+
+func TestNewManager_RejectsInvalidOptions(t *testing.T) {
+	// Each of these used to panic once the manager was in use
+	for _, tc := range []struct {
+		option  Option
+		problem string
+	}{
+		{WithPongWait(0, 0), "pong-wait"},
+		{WithSessions(time.Minute, 0), "session-buffer-size"},
+		{WithHistory(NewMemoryHistory(10, 10), 0), "history-limit"},
+	} {
+		m, err := NewManager(context.Background(), NewMemoryAuthenticator(), tc.option)
+		if err == nil || !strings.Contains(err.Error(), tc.problem) {
+			t.Errorf("expected %s to be rejected, got %v", tc.problem, err)
+		}
+		if m != nil {
+			t.Errorf("expected no manager with a invalid config")
+		}
+	}
+}
+
+func TestManager_AllowedOrigins(t *testing.T) {
+	testCases := []struct {
+		name    string
+		opts    []Option
+		origin  string
+		allowed bool
+	}{
+		{name: "default", origin: "https://localhost:8080", allowed: true},
+		{name: "default rejects others", origin: "https://evil.example.com", allowed: false},
+		{name: "configured", opts: []Option{WithAllowedOrigins("https://chat.example.com")}, origin: "https://chat.example.com", allowed: true},
+		{name: "wildcard", opts: []Option{WithAllowedOrigins("*")}, origin: "https://evil.example.com", allowed: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, srv := newTestServer(t, tc.opts...)
+			otp, _ := login(t, srv, "percy", "123")
+
+			header := http.Header{}
+			header.Set("Origin", tc.origin)
+			conn, resp, err := websocket.DefaultDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1)+"/ws?otp="+otp, header)
+			if conn != nil {
+				conn.Close()
+			}
+			if resp != nil {
+				io.Copy(io.Discard, resp.Body)
+			}
+			if (err == nil) != tc.allowed {
+				t.Errorf("expected allowed=%v, got error %v", tc.allowed, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// EventSendDirect is sent by a client to send a private message to a single user
+	EventSendDirect = "send_direct"
+	// EventNewDirect is sent to every client of the receiver and to the other clients of the sender
+	EventNewDirect = "new_direct"
+	// EventLoadDirectHistory is a RPC used to page back through the conversation with a user
+	EventLoadDirectHistory = "load_direct_history"
+	// EventBlockUser is a RPC that stops a user from sending direct messages to the caller
+	EventBlockUser = "block_user"
+	// EventUnblockUser is a RPC that allows a blocked user to send direct messages again
+	EventUnblockUser = "unblock_user"
+)
+
+// Error codes of the direct messages
+const (
+	// ErrCodeUnknownUser is used when a direct message is sent to a user that does not exist
+	ErrCodeUnknownUser = "unknown_user"
+	// ErrCodeBlocked is used when the receiver of a direct message has blocked the sender
+	ErrCodeBlocked = "blocked"
+)
+
+var (
+	// ErrUnknownUser is returned when the receiver of a direct message does not exist
+	ErrUnknownUser = NewHandlerError(ErrCodeUnknownUser, "user does not exist")
+	// ErrBlocked is returned when the receiver of a direct message has blocked the sender
+	ErrBlocked = NewHandlerError(ErrCodeBlocked, "user has blocked you")
+	// ErrSelfDirect is returned when a user tries to message or block itself
+	ErrSelfDirect = NewHandlerError(ErrCodeInvalid, "can not do that to yourself")
+)
+
+// SendDirectEvent is the payload of send_direct
+type SendDirectEvent struct {
+	To      string `json:"to"`
+	Message string `json:"message"`
+}
+
+// DirectMessageEvent is the payload of new_direct, the ID is counted per conversation
+type DirectMessageEvent struct {
+	// Conversation is the ID the messages between the two users are stored under
+	Conversation string `json:"conversation"`
+	To           string `json:"to"`
+	HistoryMessage
+}
+
+// LoadDirectHistoryRequest is the parameters of load_direct_history, With is the other user
+type LoadDirectHistoryRequest struct {
+	With string `json:"with"`
+	LoadHistoryRequest
+}
+
+// BlockUserRequest is the parameters of block_user and unblock_user
+type BlockUserRequest struct {
+	Username string `json:"username"`
+}
+
+// BlockList remembers which users each user has blocked
+type BlockList interface {
+	// Block stops blocked from sending direct messages to username
+	Block(username, blocked string) error
+	// Unblock allows blocked to send direct messages to username again
+	Unblock(username, blocked string) error
+	// IsBlocked reports if username has blocked sender
+	IsBlocked(username, sender string) (bool, error)
+}
+
+// conversationID is the ID a conversation is stored under, it is the same whoever sends.
+// The usernames are escaped so no two pairs of users end up with the same ID
+func conversationID(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return "dm:" + url.QueryEscape(a) + ":" + url.QueryEscape(b)
+}
+
+// SendDirectHandler stores a private message and sends it to every client of the receiver,
+// and to the other clients of the sender so all its tabs show the conversation
+func SendDirectHandler(event Event, c *Client) error {
+	var request SendDirectEvent
+	if err := event.Payload.Decode(&request); err != nil {
+		return errBadPayload(err)
+	}
+	if request.To == "" {
+		return NewHandlerError(ErrCodeInvalid, "to can not be empty")
+	}
+	if request.To == c.identity.Username {
+		return ErrSelfDirect
+	}
+
+	m := c.manager
+	exists, err := m.userExists(request.To)
+	if err != nil {
+		return fmt.Errorf("failed to look up user: %w", err)
+	}
+	if !exists {
+		return ErrUnknownUser
+	}
+	blocked, err := m.config.Blocks.IsBlocked(request.To, c.identity.Username)
+	if err != nil {
+		return fmt.Errorf("failed to check block list: %w", err)
+	}
+	if blocked {
+		return ErrBlocked
+	}
+
+	conversation := conversationID(c.identity.Username, request.To)
+	var message NewMessageEvent
+	message.Message = request.Message
+	message.From = c.identity.Username
+	message.Sent = time.Now()
+	stored, err := m.config.DirectHistory.Append(conversation, message)
+	if err != nil {
+		return fmt.Errorf("failed to store direct message: %w", err)
+	}
+
+	outgoingEvent := Event{
+		Type:    EventNewDirect,
+		Payload: NewPayload(DirectMessageEvent{Conversation: conversation, To: request.To, HistoryMessage: stored}),
+	}.WithContext(event.Context())
+	// The sending client already has the message, the ack tells it that it was delivered
+	m.sendToUsers(outgoingEvent, c, request.To, c.identity.Username)
+	return nil
+}
+
+// LoadDirectHistoryHandler returns older messages of the conversation with a user.
+// The limit is capped at the configured HistoryLimit
+func LoadDirectHistoryHandler(ctx context.Context, event Event, c *Client) (any, error) {
+	var request LoadDirectHistoryRequest
+	if err := event.Payload.Decode(&request); err != nil {
+		return nil, errBadPayload(err)
+	}
+	if request.With == "" {
+		return nil, NewHandlerError(ErrCodeInvalid, "with can not be empty")
+	}
+	if request.Before < 0 || request.Limit < 0 {
+		return nil, NewHandlerError(ErrCodeInvalid, "before and limit can not be negative")
+	}
+
+	cfg := c.manager.config
+	conversation := conversationID(c.identity.Username, request.With)
+	return historyPage(cfg.DirectHistory, conversation, request.Before, cfg.pageLimit(request.Limit))
+}
+
+// BlockUserHandler stops a user from sending direct messages to the caller
+func BlockUserHandler(ctx context.Context, event Event, c *Client) (any, error) {
+	var request BlockUserRequest
+	if err := event.Payload.Decode(&request); err != nil {
+		return nil, errBadPayload(err)
+	}
+	if request.Username == "" {
+		return nil, NewHandlerError(ErrCodeInvalid, "username can not be empty")
+	}
+	if request.Username == c.identity.Username {
+		return nil, ErrSelfDirect
+	}
+	if err := c.manager.config.Blocks.Block(c.identity.Username, request.Username); err != nil {
+		return nil, fmt.Errorf("failed to block user: %w", err)
+	}
+	return nil, nil
+}
+
+// UnblockUserHandler allows a blocked user to send direct messages to the caller again
+func UnblockUserHandler(ctx context.Context, event Event, c *Client) (any, error) {
+	var request BlockUserRequest
+	if err := event.Payload.Decode(&request); err != nil {
+		return nil, errBadPayload(err)
+	}
+	if request.Username == "" {
+		return nil, NewHandlerError(ErrCodeInvalid, "username can not be empty")
+	}
+	if err := c.manager.config.Blocks.Unblock(c.identity.Username, request.Username); err != nil {
+		return nil, fmt.Errorf("failed to unblock user: %w", err)
+	}
+	return nil, nil
+}
+
+// userExists asks the Authenticator if it is a UserDirectory, otherwise only connected users
+// and users with a session waiting to be resumed are known
+func (m *Manager) userExists(username string) (bool, error) {
+	if directory, ok := m.auth.(UserDirectory); ok {
+		exists, err := directory.UserExists(username)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return exists, err
+		}
+	}
+	return len(m.clientsOf(username)) > 0 || len(m.detachedSessionsOf(username)) > 0, nil
+}
+
+// clientsOf returns the connected clients of the users
+func (m *Manager) clientsOf(usernames ...string) []*Client {
+	m.RLock()
+	defer m.RUnlock()
+
+	var clients []*Client
+	for client := range m.clients {
+		for _, username := range usernames {
+			if client.identity.Username == username {
+				clients = append(clients, client)
+				break
+			}
+		}
+	}
+	return clients
+}
+
+// sendToUsers sends the event to every session of the users except the one of skip. Sessions that are
+// detached number and buffer it like what is said in their room, so it is replayed when they resume.
+// Clients without a session get it only while connected
+func (m *Manager) sendToUsers(event Event, skip *Client, usernames ...string) {
+	sessions := make(map[*session]bool)
+	for _, client := range m.clientsOf(usernames...) {
+		switch {
+		case client == skip:
+		case client.session != nil:
+			sessions[client.session] = true
+		default:
+			client.Send(event)
+		}
+	}
+	for _, s := range m.detachedSessionsOf(usernames...) {
+		if skip == nil || s != skip.session {
+			sessions[s] = true
+		}
+	}
+	// A client that detached after clientsOf is found twice, the map sends to its session once
+	for s := range sessions {
+		s.send(event)
+	}
+}
+
+// detachedSessionsOf returns the sessions of the users that are waiting to be resumed
+func (m *Manager) detachedSessionsOf(usernames ...string) []*session {
+	m.sessions.Lock()
+	defer m.sessions.Unlock()
+
+	var detached []*session
+	for _, s := range m.sessions.sessions {
+		s.Lock()
+		// A session that never had a client has nothing to resume from yet
+		waiting := s.client != nil && !s.attached && !s.expired
+		s.Unlock()
+		if waiting && slices.Contains(usernames, s.identity.Username) {
+			detached = append(detached, s)
+		}
+	}
+	return detached
+}
+
+// MemoryBlockList is a BlockList that forgets everything on restart
+type MemoryBlockList struct {
+	sync.RWMutex
+	// blocks holds the users each user has blocked
+	blocks map[string]map[string]bool
+}
+
+// NewMemoryBlockList creates a empty MemoryBlockList
+func NewMemoryBlockList() *MemoryBlockList {
+	return &MemoryBlockList{
+		blocks: make(map[string]map[string]bool),
+	}
+}
+
+// Block stops blocked from sending direct messages to username
+func (bl *MemoryBlockList) Block(username, blocked string) error {
+	bl.Lock()
+	defer bl.Unlock()
+
+	if bl.blocks[username] == nil {
+		bl.blocks[username] = make(map[string]bool)
+	}
+	bl.blocks[username][blocked] = true
+	return nil
+}
+
+// Unblock allows blocked to send direct messages to username again
+func (bl *MemoryBlockList) Unblock(username, blocked string) error {
+	bl.Lock()
+	defer bl.Unlock()
+
+	delete(bl.blocks[username], blocked)
+	return nil
+}
+
+// IsBlocked reports if username has blocked sender
+func (bl *MemoryBlockList) IsBlocked(username, sender string) (bool, error) {
+	bl.RLock()
+	defer bl.RUnlock()
+
+	return bl.blocks[username][sender], nil
+}
@@ -0,0 +1,141 @@
+package hub
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConversationID(t *testing.T) {
+	if conversationID("percy", "anna") != conversationID("anna", "percy") {
+		t.Error("expected both users to share the conversation")
+	}
+	// A colon in a username should not make two conversations collide
+	if conversationID("a:b", "c") == conversationID("a", "b:c") {
+		t.Error("expected escaped usernames to keep conversations apart")
+	}
+}
+
+func TestManager_SendDirect(t *testing.T) {
+	auth := NewMemoryAuthenticator()
+	for _, username := range []string{"percy", "anna", "bob"} {
+		auth.AddUser(username, "123")
+	}
+	m, srv := newTestServerWithAuth(t, auth)
+	percy := connect(t, srv, "percy")
+	percyTab := connect(t, srv, "percy")
+	annaTab1 := connect(t, srv, "anna")
+	annaTab2 := connect(t, srv, "anna")
+	bob := connect(t, srv, "bob")
+	waitForClients(t, m, 5)
+
+	// Direct messages do not care about rooms
+	sendEvent(t, annaTab2, EventChangeRoom, ChangeRoomEvent{Name: "games"})
+	waitFor(t, "anna to change room", func() bool { return len(m.rooms.Members("games")) == 1 })
+
+	call(t, percy, "1", EventSendDirect, SendDirectEvent{To: "anna", Message: "psst"})
+	readUntil(t, percy, EventAck, nil, EventNewDirect)
+	for _, conn := range []*websocket.Conn{percyTab, annaTab1, annaTab2} {
+		var direct DirectMessageEvent
+		readUntil(t, conn, EventNewDirect, &direct)
+		if direct.From != "percy" || direct.To != "anna" || direct.Message != "psst" || direct.ID != 1 ||
+			direct.Conversation != conversationID("percy", "anna") {
+			t.Errorf("unexpected direct message %+v", direct)
+		}
+	}
+	// bob is not part of the conversation
+	sendEvent(t, bob, EventSendMessage, SendMessageEvent{Message: "anyone?"})
+	readUntil(t, bob, EventNewMessage, nil, EventNewDirect)
+
+	var history HistoryEvent
+	call(t, annaTab1, "2", EventLoadDirectHistory, LoadDirectHistoryRequest{With: "percy"})
+	readUntil(t, annaTab1, EventReply, &history, EventError)
+	if len(history.Messages) != 1 || history.Messages[0].Message != "psst" {
+		t.Errorf("expected the message in the history, got %+v", history)
+	}
+
+	var failed ErrorEvent
+	call(t, percy, "3", EventSendDirect, SendDirectEvent{To: "nobody", Message: "hello?"})
+	readUntil(t, percy, EventError, &failed, EventAck)
+	if failed.Code != ErrCodeUnknownUser {
+		t.Errorf("expected %s, got %+v", ErrCodeUnknownUser, failed)
+	}
+
+	call(t, annaTab1, "4", EventBlockUser, BlockUserRequest{Username: "percy"})
+	readUntil(t, annaTab1, EventReply, nil, EventError)
+	call(t, percy, "5", EventSendDirect, SendDirectEvent{To: "anna", Message: "hello?"})
+	readUntil(t, percy, EventError, &failed, EventAck)
+	if failed.Code != ErrCodeBlocked {
+		t.Errorf("expected %s, got %+v", ErrCodeBlocked, failed)
+	}
+	call(t, bob, "6", EventSendDirect, SendDirectEvent{To: "anna", Message: "hi"})
+	readUntil(t, bob, EventAck, nil, EventError)
+
+	call(t, annaTab1, "7", EventUnblockUser, BlockUserRequest{Username: "percy"})
+	readUntil(t, annaTab1, EventReply, nil, EventError)
+	call(t, percy, "8", EventSendDirect, SendDirectEvent{To: "anna", Message: "sorry"})
+	readUntil(t, percy, EventAck, nil, EventError)
+}
+
+func TestManager_SendDirectWhileDetached(t *testing.T) {
+	m, srv := newTestServer(t)
+	percy := connect(t, srv, "percy")
+	otp, session := loginSession(t, srv, "anna")
+	anna, _, err := dial(srv, otp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForClients(t, m, 2)
+
+	// anna is reconnecting when the message is sent
+	anna.Close()
+	waitForClients(t, m, 1)
+	call(t, percy, "1", EventSendDirect, SendDirectEvent{To: "anna", Message: "still there?"})
+	readUntil(t, percy, EventAck, nil, EventError)
+
+	anna, _, err = resume(srv, session, 0)
+	if err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+	defer anna.Close()
+	var resumed SessionResumedEvent
+	readUntil(t, anna, EventSessionResumed, &resumed)
+
+	event := readUntil(t, anna, EventNewDirect, nil)
+	var direct DirectMessageEvent
+	if err := event.Payload.Decode(&direct); err != nil {
+		t.Fatal(err)
+	}
+	if direct.Message != "still there?" || event.Seq == 0 || event.Seq > resumed.LastSeq+uint64(resumed.Replayed) {
+		t.Errorf("expected the direct message to be replayed, got %+v with seq %d after %+v", direct, event.Seq, resumed)
+	}
+}
+
+func TestManager_SendDirectWithoutUserDirectory(t *testing.T) {
+	// Only connected users are known when the authenticator can not list users
+	m, srv := newTestServerWithAuth(t, NewLockoutAuthenticator(passwordAuthenticator("123"), 5, 0))
+	percy := connect(t, srv, "percy")
+	waitForClients(t, m, 1)
+
+	var failed ErrorEvent
+	call(t, percy, "1", EventSendDirect, SendDirectEvent{To: "anna", Message: "hi"})
+	readUntil(t, percy, EventError, &failed)
+	if failed.Code != ErrCodeUnknownUser {
+		t.Errorf("expected %s while anna is offline, got %+v", ErrCodeUnknownUser, failed)
+	}
+
+	connect(t, srv, "anna")
+	waitForClients(t, m, 2)
+	call(t, percy, "2", EventSendDirect, SendDirectEvent{To: "anna", Message: "hi"})
+	readUntil(t, percy, EventAck, nil, EventError)
+}
+
+// passwordAuthenticator lets anyone in with the password, it knows no users
+type passwordAuthenticator string
+
+func (p passwordAuthenticator) Authenticate(username, password string) (Identity, error) {
+	if password != string(p) {
+		return Identity{}, ErrInvalidCredentials
+	}
+	return Identity{Username: username}, nil
+}
@@ -0,0 +1,14 @@
+// Package hub is a websocket chat hub that can be embedded in any HTTP server.
+//
+// A Manager keeps track of all connected clients and routes the events they send
+// to the registered handlers. Users login through LoginHandler to get a one time
+// password, which is then used to open the websocket served by ServeWS.
+//
+//	manager, err := hub.NewManager(ctx, auth)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	manager.RegisterHandler("shout", shoutHandler)
+//	http.HandleFunc("/login", manager.LoginHandler)
+//	http.HandleFunc("/ws", manager.ServeWS)
+package hub
@@ -0,0 +1,180 @@
+package hub
+
+import (
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// OverflowPolicy decides what happens to a event when a clients egress queue is full
+type OverflowPolicy int
+
+const (
+	// DropOldest throws away the oldest queued event to make room for the new one
+	DropOldest OverflowPolicy = iota
+	// DropNewest throws away the new event and keeps the queue as is
+	DropNewest
+	// Disconnect closes the connection of the slow client using the configured close code
+	Disconnect
+	// Block waits for room in the queue, if none appears within the timeout the client is disconnected
+	Block
+)
+
+// String returns the name of the policy as used in logs
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop_oldest"
+	case DropNewest:
+		return "drop_newest"
+	case Disconnect:
+		return "disconnect"
+	case Block:
+		return "block"
+	default:
+		return "unknown"
+	}
+}
+
+// Set parses the name of a policy as returned by String, it makes OverflowPolicy usable as a flag
+func (p *OverflowPolicy) Set(value string) error {
+	for _, policy := range []OverflowPolicy{DropOldest, DropNewest, Disconnect, Block} {
+		if policy.String() == value {
+			*p = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown overflow policy %q", value)
+}
+
+// EgressConfig configures the outbound queue of each client
+type EgressConfig struct {
+	// QueueSize is how many events can wait to be written to a client
+	QueueSize int
+	// Policy is what to do once the queue is full
+	Policy OverflowPolicy
+	// BlockTimeout is how long the Block policy waits for room in the queue
+	BlockTimeout time.Duration
+	// CloseCode is sent to clients that are disconnected for being too slow
+	CloseCode int
+}
+
+// DefaultEgressConfig is used by the Manager unless anything else is configured
+var DefaultEgressConfig = EgressConfig{
+	QueueSize:    64,
+	Policy:       DropOldest,
+	BlockTimeout: time.Second,
+	CloseCode:    websocket.CloseTryAgainLater,
+}
+
+// EgressStats are counters of how the egress queues has been coping
+type EgressStats struct {
+	// Dropped is the number of events that was thrown away because a queue was full
+	Dropped int64
+	// SlowConsumers is the number of times a client has been marked as slow
+	SlowConsumers int64
+	// Disconnected is the number of clients that was disconnected for being slow
+	Disconnected int64
+}
+
+// egressMetrics holds the counters behind EgressStats, only use it with sync/atomic
+type egressMetrics struct {
+	dropped       int64
+	slowConsumers int64
+	disconnected  int64
+}
+
+func (em *egressMetrics) snapshot() EgressStats {
+	return EgressStats{
+		Dropped:       atomic.LoadInt64(&em.dropped),
+		SlowConsumers: atomic.LoadInt64(&em.slowConsumers),
+		Disconnected:  atomic.LoadInt64(&em.disconnected),
+	}
+}
+
+// Send places the event in the clients egress queue, applying the overflow policy
+// if the client can not keep up. It returns false if the event was not queued.
+// Clients with a session get the event numbered and buffered for a resume first
+func (c *Client) Send(event Event) bool {
+	if c.session != nil {
+		return c.session.send(event)
+	}
+	return c.enqueue(event)
+}
+
+// enqueue does the queueing for Send
+func (c *Client) enqueue(event Event) bool {
+	// Fast path, there is room in the queue
+	select {
+	case c.egress <- event:
+		return true
+	case <-c.done:
+		return false
+	default:
+	}
+
+	cfg := c.manager.config.Egress
+	metrics := &c.manager.egressMetrics
+	c.markSlow(cfg.Policy)
+
+	switch cfg.Policy {
+	case DropNewest:
+		atomic.AddInt64(&metrics.dropped, 1)
+		return false
+	case Disconnect:
+		atomic.AddInt64(&metrics.disconnected, 1)
+		c.close(cfg.CloseCode, "client is too slow")
+		return false
+	case Block:
+		timer := time.NewTimer(cfg.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case c.egress <- event:
+			return true
+		case <-c.done:
+			return false
+		case <-timer.C:
+			c.log(slog.LevelWarn, "client did not drain its queue in time, disconnecting", "timeout", cfg.BlockTimeout)
+			atomic.AddInt64(&metrics.disconnected, 1)
+			c.close(cfg.CloseCode, "client is too slow")
+			return false
+		}
+	default:
+		// DropOldest, keep throwing away the head of the queue until the event fits.
+		// Other senders may be racing for the same slot so it can take a few rounds
+		for {
+			select {
+			case c.egress <- event:
+				return true
+			case <-c.done:
+				return false
+			default:
+			}
+			select {
+			case <-c.egress:
+				atomic.AddInt64(&metrics.dropped, 1)
+			default:
+			}
+		}
+	}
+}
+
+// markSlow flags the client as a slow consumer, it is only logged and counted the
+// first time until the writer has caught up again
+func (c *Client) markSlow(policy OverflowPolicy) {
+	if !atomic.CompareAndSwapInt32(&c.slow, 0, 1) {
+		return
+	}
+	atomic.AddInt64(&c.manager.egressMetrics.slowConsumers, 1)
+	c.log(slog.LevelWarn, "slow consumer, egress queue is full", "queue_size", cap(c.egress), "policy", policy.String())
+}
+
+// caughtUp is called by the writer when the queue is empty so the client can be marked slow again
+func (c *Client) caughtUp() {
+	if len(c.egress) == 0 {
+		atomic.StoreInt32(&c.slow, 0)
+	}
+}
@@ -0,0 +1,144 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newStalledClient creates a client whose writer is never started, just like a
+// browser that has stopped reading from the socket
+func newStalledClient(t *testing.T, cfg EgressConfig) (*Manager, *Client) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	m, err := NewManager(ctx, NewMemoryAuthenticator(), WithEgress(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, NewClient(nil, m, Identity{Username: "percy"})
+}
+
+func numberedEvent(i int) Event {
+	return Event{Type: EventNewMessage, Payload: NewPayload(json.RawMessage(fmt.Sprint(i)))}
+}
+
+func TestClient_SendDropOldest(t *testing.T) {
+	m, c := newStalledClient(t, EgressConfig{QueueSize: 2, Policy: DropOldest})
+
+	for i := 0; i < 5; i++ {
+		if !c.Send(numberedEvent(i)) {
+			t.Fatalf("event %d should have been queued", i)
+		}
+	}
+
+	// Only the two newest events should be left
+	for _, want := range []string{"3", "4"} {
+		if got := (<-c.egress).Payload.String(); got != want {
+			t.Errorf("expected event %s, got %s", want, got)
+		}
+	}
+	stats := m.EgressStats()
+	if stats.Dropped != 3 || stats.SlowConsumers != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestClient_SendDropNewest(t *testing.T) {
+	m, c := newStalledClient(t, EgressConfig{QueueSize: 2, Policy: DropNewest})
+
+	for i := 0; i < 5; i++ {
+		if queued := c.Send(numberedEvent(i)); queued != (i < 2) {
+			t.Errorf("event %d queued=%v", i, queued)
+		}
+	}
+
+	for _, want := range []string{"0", "1"} {
+		if got := (<-c.egress).Payload.String(); got != want {
+			t.Errorf("expected event %s, got %s", want, got)
+		}
+	}
+	if stats := m.EgressStats(); stats.Dropped != 3 {
+		t.Errorf("expected 3 dropped events, got %+v", stats)
+	}
+}
+
+func TestClient_SendDisconnect(t *testing.T) {
+	m, c := newStalledClient(t, EgressConfig{QueueSize: 1, Policy: Disconnect, CloseCode: websocket.CloseTryAgainLater})
+
+	c.Send(numberedEvent(0))
+	if c.Send(numberedEvent(1)) {
+		t.Error("the overflowing event should not be queued")
+	}
+
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("the client should be closed")
+	}
+	if c.closeCode != websocket.CloseTryAgainLater {
+		t.Errorf("expected close code %d, got %d", websocket.CloseTryAgainLater, c.closeCode)
+	}
+	if c.Send(numberedEvent(2)) {
+		t.Error("nothing should be queued on a closed client")
+	}
+	if stats := m.EgressStats(); stats.Disconnected != 1 {
+		t.Errorf("expected one disconnect, got %+v", stats)
+	}
+}
+
+func TestClient_SendBlock(t *testing.T) {
+	_, c := newStalledClient(t, EgressConfig{QueueSize: 1, Policy: Block, BlockTimeout: 50 * time.Millisecond, CloseCode: websocket.CloseTryAgainLater})
+
+	c.Send(numberedEvent(0))
+
+	// Make room before the timeout, the sender should wait for it
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-c.egress
+	}()
+	if !c.Send(numberedEvent(1)) {
+		t.Fatal("the event should be queued once there is room")
+	}
+
+	// Nobody drains the queue this time
+	start := time.Now()
+	if c.Send(numberedEvent(2)) {
+		t.Fatal("the event should not be queued when the queue never drains")
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("expected to block for the timeout, only waited %s", waited)
+	}
+	select {
+	case <-c.done:
+	default:
+		t.Error("the client should be disconnected after the timeout")
+	}
+}
+
+// TestManager_SlowClientDoesNotBlockRoom makes sure one stalled client can not hold up the others
+func TestManager_SlowClientDoesNotBlockRoom(t *testing.T) {
+	m, srv := newTestServer(t, WithEgress(EgressConfig{QueueSize: 1, Policy: DropNewest}))
+
+	percy := connect(t, srv, "percy")
+	waitForClients(t, m, 1)
+	// anna is placed in the room but her writer is never started
+	stalled := NewClient(nil, m, Identity{Username: "anna"})
+	m.rooms.Join(stalled, DefaultRoom)
+
+	for i := 0; i < 3; i++ {
+		sendEvent(t, percy, EventSendMessage, SendMessageEvent{Message: fmt.Sprint(i)})
+		var msg NewMessageEvent
+		if err := readEvent(t, percy).Payload.Decode(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Message != fmt.Sprint(i) {
+			t.Errorf("expected message %d, got %s", i, msg.Message)
+		}
+	}
+}
@@ -1,18 +1,38 @@
-package main //@包主
+package hub
 
 import ( //@进口
-	"encoding/json" //@编码json
+	"context"
 	"fmt" //@调速器
 	"time" //@时间
 )
 
+var (
+	// ErrSenderMismatch is returned when a client tries to send a message as another user
+	ErrSenderMismatch = NewHandlerError(ErrCodeForbidden, "from does not match the authenticated user")
+	// ErrNotInRoom is returned when a client that has left its room tries to talk in it
+	ErrNotInRoom = NewHandlerError(ErrCodeInvalid, "client is not in a room")
+	// ErrEmptyRoomName is returned when trying to change into a room without a name
+	ErrEmptyRoomName = NewHandlerError(ErrCodeInvalid, "room name can not be empty")
+)
+
 // Event is the Messages sent over the websocket //@事件是通过 websocket 发送的消息
 // Used to differ between different actions //@用于区分不同的动作
 type Event struct { //@类型事件结构
 	// Type is the message type sent //@type 是发送的消息类型
 	Type string `json:"type"` //@类型 字符串 json 类型
 	// Payload is the data Based on the Type //@payload是基于类型的数据
-	Payload json.RawMessage `json:"payload"` //@有效载荷 json 原始消息 json 有效载荷
+	// It is decoded by the handler, with the codec of the connection the event arrived on
+	Payload Payload `json:"payload"`
+	// ID is optional, when a client sets it the server replies with a ack or error event carrying the same id
+	ID string `json:"id,omitempty"`
+	// ReplyTo is set on replies and holds the ID of the event that is answered
+	ReplyTo string `json:"reply_to,omitempty"`
+	// Seq numbers the events sent to a session, pass the last one seen when resuming it
+	Seq uint64 `json:"seq,omitempty"`
+	// Metadata travels along with the event, it carries the W3C trace context as traceparent and tracestate
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// ctx is the context a received event is handled in, it holds the span of the handler
+	ctx context.Context
 }
 
 
@@ -32,12 +52,14 @@ const ( //@常数
 
 // SendMessageEvent is the payload sent in the //@发送消息事件是在
 // send_message event //@发送消息事件
+// From is always set by the server to the authenticated user, clients can leave it out
 type SendMessageEvent struct { //@类型发送消息事件结构
 	Message string `json:"message"` //@消息字符串 json 消息
 	From    string `json:"from"` //@来自字符串 json 来自
 }
 
 // NewMessageEvent is returned when responding to send_message //@响应发送消息时返回新消息事件
+// The new_message event carries it as a HistoryMessage, with the id it was stored under
 type NewMessageEvent struct { //@输入新消息事件结构
 	SendMessageEvent //@发送消息事件
 	Sent time.Time `json:"sent"` //@发送时间json发送时间
@@ -49,8 +71,12 @@ type NewMessageEvent struct { //@输入新消息事件结构
 func SendMessageHandler(event Event, c *Client) error { //@func 发送消息处理程序事件 event c 客户端错误
 	// Marshal Payload into wanted format //@将有效载荷编组为所需格式
 	var chatevent SendMessageEvent //@var chatevent 发送消息事件
-	if err := json.Unmarshal(event.Payload, &chatevent); err != nil {
-		return fmt.Errorf("bad payload in request: %v", err) //@在请求 v err 中返回 fmt error bad payload
+	if err := event.Payload.Decode(&chatevent); err != nil {
+		return errBadPayload(err)
+	}
+	// Never trust the client to say who it is, only the identity from the login counts
+	if chatevent.From != "" && chatevent.From != c.identity.Username {
+		return ErrSenderMismatch
 	}
 
 	// Prepare an Outgoing Message to others //@准备外发消息给他人
@@ -58,25 +84,29 @@ func SendMessageHandler(event Event, c *Client) error { //@func 发送消息处
 
 	broadMessage.Sent = time.Now() //@广泛的消息发送时间现在
 	broadMessage.Message = chatevent.Message //@广泛的消息消息 chatevent 消息
-	broadMessage.From = chatevent.From //@来自 chatevent 的广泛信息
+	broadMessage.From = c.identity.Username
 
-	data, err := json.Marshal(broadMessage) //@数据错误 json 编组广泛消息
-	if err != nil { //@如果错误为零
-		return fmt.Errorf("failed to marshal broadcast message: %v", err) //@返回 fmt errorf 无法编组广播消息 v err
+	room, ok := c.manager.rooms.RoomOf(c)
+	if !ok {
+		return ErrNotInRoom
+	}
+	// The message is out, so the user is done typing it
+	c.stopTyping()
+	// Store it first, the broadcast carries the id so clients can tell it apart from the history
+	stored, err := c.manager.config.History.Append(room, broadMessage)
+	if err != nil {
+		return fmt.Errorf("failed to store message: %w", err)
 	}
 
 	// Place payload into an Event //@将有效载荷放入事件中
 	var outgoingEvent Event //@var 传出事件事件
-	outgoingEvent.Payload = data //@传出事件负载数据
+	// Every client encodes it with its own codec
+	outgoingEvent.Payload = NewPayload(stored)
 	outgoingEvent.Type = EventNewMessage //@传出事件类型事件新消息
-	// Broadcast to all other Clients //@广播给所有其他客户端
-	for client := range c.manager.clients { //@对于客户范围 c 经理客户
-		// Only send to clients inside the same chatroom //@只发送给同一个聊天室内的客户
-		if client.chatroom == c.chatroom { //@如果客户端聊天室 c 聊天室
-			client.egress <- outgoingEvent //@客户端出口传出事件
-		}
-
-	}
+	// Let the trace follow the message to every receiver
+	outgoingEvent = outgoingEvent.WithContext(event.Context())
+	// Broadcast to all other Clients in the same chatroom
+	c.manager.Broadcast(room, outgoingEvent)
 	return nil //@返回零
 }
 
@@ -88,12 +118,26 @@ type ChangeRoomEvent struct { //@类型更改房间事件结构
 func ChatRoomHandler(event Event, c *Client) error { //@func 聊天室处理程序事件 event c 客户端错误
 	// Marshal Payload into wanted format //@将有效载荷编组为所需格式
 	var changeRoomEvent ChangeRoomEvent //@var 换房事件 换房事件
-	if err := json.Unmarshal(event.Payload, &changeRoomEvent); err != nil {
-		return fmt.Errorf("bad payload in request: %v", err) //@在请求 v err 中返回 fmt error bad payload
+	if err := event.Payload.Decode(&changeRoomEvent); err != nil {
+		return errBadPayload(err)
+	}
+
+	if changeRoomEvent.Name == "" {
+		return ErrEmptyRoomName
 	}
 
+	// Remember the room first, so a failing store does not leave the client somewhere unexpected
+	if err := c.manager.config.RoomStore.SaveMembership(c.identity.Username, changeRoomEvent.Name); err != nil {
+		return fmt.Errorf("failed to save room: %w", err)
+	}
+	// Typing in the old room is over
+	c.stopTyping()
 	// Add Client to chat room //@将客户端添加到聊天室
-	c.chatroom = changeRoomEvent.Name //@c 聊天室更改房间事件名称
+	_, changes := c.manager.rooms.join(c, changeRoomEvent.Name)
+	// Show who is there, then let both rooms know the user moved
+	c.sendPresence(changeRoomEvent.Name)
+	c.manager.announce(changes)
 
-	return nil //@返回零
+	// Catch the client up on what was said before it joined
+	return c.sendHistory(changeRoomEvent.Name)
 }
@@ -0,0 +1,50 @@
+package hub
+
+import (
+	"testing"
+)
+
+func TestSendMessageHandler_StampsSender(t *testing.T) {
+	m, srv := newTestServer(t)
+
+	percy := connect(t, srv, "percy")
+	anna := connect(t, srv, "anna")
+	waitForClients(t, m, 2)
+
+	// anna tries to post as percy, this should never reach anyone
+	sendEvent(t, anna, EventSendMessage, SendMessageEvent{Message: "spoofed", From: "percy"})
+	// Leaving out from is fine, the server knows who anna is
+	sendEvent(t, anna, EventSendMessage, SendMessageEvent{Message: "hello"})
+
+	event := readEvent(t, percy)
+	if event.Type != EventNewMessage {
+		t.Fatalf("expected %s, got %s", EventNewMessage, event.Type)
+	}
+	var msg NewMessageEvent
+	if err := event.Payload.Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Message != "hello" {
+		t.Errorf("the spoofed message was delivered: %q", msg.Message)
+	}
+	if msg.From != "anna" {
+		t.Errorf("expected the message to be from anna, got %q", msg.From)
+	}
+}
+
+func TestSendMessageHandler_OwnNameIsAccepted(t *testing.T) {
+	m, srv := newTestServer(t)
+
+	percy := connect(t, srv, "percy")
+	waitForClients(t, m, 1)
+
+	sendEvent(t, percy, EventSendMessage, SendMessageEvent{Message: "hi", From: "percy"})
+
+	var msg NewMessageEvent
+	if err := readEvent(t, percy).Payload.Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.From != "percy" || msg.Message != "hi" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+}
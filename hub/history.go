@@ -0,0 +1,346 @@
+package hub
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// EventHistory is sent to a client joining a room and holds the latest messages of it
+	EventHistory = "history"
+	// EventLoadHistory is a RPC used to page back through the history of the current room
+	EventLoadHistory = "load_history"
+)
+
+const (
+	// DefaultHistoryCapacity is how many messages per room NewManager keeps in memory when no HistoryStore is configured
+	DefaultHistoryCapacity = 1000
+	// DefaultHistoryRooms is how many rooms NewManager keeps the history of in memory when no HistoryStore is configured
+	DefaultHistoryRooms = 1000
+)
+
+// HistoryMessage is a message stored in the history of a room.
+// IDs start at 1 and grow by one for every message in the room, they are used as paging cursors
+type HistoryMessage struct {
+	ID int64 `json:"id"`
+	NewMessageEvent
+}
+
+// HistoryStore keeps the messages sent in every room
+type HistoryStore interface {
+	// Append stores the message in room and returns it with its ID set
+	Append(room string, msg NewMessageEvent) (HistoryMessage, error)
+	// Before returns up to limit messages of room with an ID lower than before, oldest first.
+	// A before of 0 returns the newest messages
+	Before(room string, before int64, limit int) ([]HistoryMessage, error)
+}
+
+// HistoryEvent is the payload of the history event and the result of load_history
+type HistoryEvent struct {
+	Room     string           `json:"room"`
+	Messages []HistoryMessage `json:"messages"`
+	// Cursor is passed as before to load_history to get the page of older messages,
+	// it is left out when there are no older messages
+	Cursor int64 `json:"cursor,omitempty"`
+}
+
+// LoadHistoryRequest are the params of load_history
+type LoadHistoryRequest struct {
+	Before int64 `json:"before"`
+	Limit  int   `json:"limit"`
+}
+
+// historyPage reads a page of up to limit messages before the cursor and sets the cursor of the next page
+func historyPage(store HistoryStore, room string, before int64, limit int) (HistoryEvent, error) {
+	// Ask for one more than wanted to know if there is a older page
+	messages, err := store.Before(room, before, limit+1)
+	if err != nil {
+		return HistoryEvent{}, err
+	}
+
+	page := HistoryEvent{Room: room, Messages: messages}
+	if len(messages) > limit {
+		page.Messages = messages[1:]
+		page.Cursor = page.Messages[0].ID
+	}
+	if page.Messages == nil {
+		page.Messages = []HistoryMessage{}
+	}
+	return page, nil
+}
+
+// sendHistory sends the latest messages of room to the client
+func (c *Client) sendHistory(room string) error {
+	page, err := historyPage(c.manager.config.History, room, 0, c.manager.config.HistoryLimit)
+	if err != nil {
+		return err
+	}
+	c.Send(Event{Type: EventHistory, Payload: NewPayload(page)})
+	return nil
+}
+
+// LoadHistoryHandler returns older messages of the room the client is in.
+// The limit is capped at the configured HistoryLimit
+func LoadHistoryHandler(ctx context.Context, event Event, c *Client) (any, error) {
+	var request LoadHistoryRequest
+	if err := event.Payload.Decode(&request); err != nil {
+		return nil, errBadPayload(err)
+	}
+	if request.Before < 0 || request.Limit < 0 {
+		return nil, NewHandlerError(ErrCodeInvalid, "before and limit can not be negative")
+	}
+
+	room, ok := c.manager.rooms.RoomOf(c)
+	if !ok {
+		return nil, ErrNotInRoom
+	}
+	return historyPage(c.manager.config.History, room, request.Before, c.manager.config.pageLimit(request.Limit))
+}
+
+// pageLimit caps the page size a client asked for at HistoryLimit, 0 asks for a full page
+func (cfg Config) pageLimit(requested int) int {
+	if requested > 0 && requested < cfg.HistoryLimit {
+		return requested
+	}
+	return cfg.HistoryLimit
+}
+
+// MemoryHistory is a HistoryStore that keeps the latest messages of each room in a ring buffer.
+// Older messages are overwritten and the history is lost on restart. Only maxRooms rooms are kept,
+// the room with the oldest last message is forgotten to make room for a new one, as clients can make up room names
+type MemoryHistory struct {
+	sync.Mutex
+	capacity int
+	maxRooms int
+	rooms    map[string]*historyRing
+	// appended counts the appends, it orders the rooms by their last message
+	appended uint64
+}
+
+// historyRing is the ring buffer of a single room
+type historyRing struct {
+	messages []HistoryMessage
+	// start is the index of the oldest message once the ring is full
+	start int
+	// nextID is the ID given to the next appended message
+	nextID int64
+	// lastAppend is the value of appended when the last message was stored
+	lastAppend uint64
+}
+
+// NewMemoryHistory creates a MemoryHistory that keeps capacity messages per room in up to maxRooms rooms.
+// Both are at least 1, smaller values are raised to it
+func NewMemoryHistory(capacity, maxRooms int) *MemoryHistory {
+	return &MemoryHistory{
+		capacity: max(capacity, 1),
+		maxRooms: max(maxRooms, 1),
+		rooms:    make(map[string]*historyRing),
+	}
+}
+
+// Append stores the message, overwriting the oldest one if the room is full
+func (mh *MemoryHistory) Append(room string, msg NewMessageEvent) (HistoryMessage, error) {
+	mh.Lock()
+	defer mh.Unlock()
+
+	ring, ok := mh.rooms[room]
+	if !ok {
+		if len(mh.rooms) >= mh.maxRooms {
+			mh.forgetOldestRoom()
+		}
+		// The ring grows with the messages, a room with a single message does not cost a full ring
+		ring = &historyRing{nextID: 1}
+		mh.rooms[room] = ring
+	}
+
+	mh.appended++
+	ring.lastAppend = mh.appended
+	stored := HistoryMessage{ID: ring.nextID, NewMessageEvent: msg}
+	ring.nextID++
+	if len(ring.messages) < mh.capacity {
+		ring.messages = append(ring.messages, stored)
+	} else {
+		ring.messages[ring.start] = stored
+		ring.start = (ring.start + 1) % mh.capacity
+	}
+	return stored, nil
+}
+
+// forgetOldestRoom removes the room that has gone the longest without a message
+func (mh *MemoryHistory) forgetOldestRoom() {
+	var oldest string
+	var oldestAppend uint64
+	for room, ring := range mh.rooms {
+		if oldest == "" || ring.lastAppend < oldestAppend {
+			oldest, oldestAppend = room, ring.lastAppend
+		}
+	}
+	delete(mh.rooms, oldest)
+}
+
+// Before returns up to limit messages older than the before cursor
+func (mh *MemoryHistory) Before(room string, before int64, limit int) ([]HistoryMessage, error) {
+	mh.Lock()
+	defer mh.Unlock()
+
+	ring, ok := mh.rooms[room]
+	if !ok || limit <= 0 {
+		return nil, nil
+	}
+
+	// IDs in the ring are consecutive, so the wanted range can be computed
+	oldest := ring.nextID - int64(len(ring.messages))
+	end := ring.nextID
+	if before > 0 && before < end {
+		end = before
+	}
+	begin := end - int64(limit)
+	if begin < oldest {
+		begin = oldest
+	}
+
+	var messages []HistoryMessage
+	for id := begin; id < end; id++ {
+		index := (ring.start + int(id-oldest)) % len(ring.messages)
+		messages = append(messages, ring.messages[index])
+	}
+	return messages, nil
+}
+
+// FileHistory is a HistoryStore that appends every message as a JSON line to a file.
+// The position of each message is kept in memory so pages can be read without scanning the file
+type FileHistory struct {
+	sync.Mutex
+	file *os.File
+	// size is where the next line is written
+	size int64
+	// lines are the positions of the messages in the file by room, the message with ID n is at index n-1
+	lines map[string][]fileLine
+}
+
+// fileLine is the position of a stored message in the file
+type fileLine struct {
+	offset int64
+	length int
+}
+
+// fileRecord is a line in the history file
+type fileRecord struct {
+	Room string `json:"room"`
+	HistoryMessage
+}
+
+// NewFileHistory opens or creates the history file at path and indexes the messages in it.
+// A half written last line, like after a crash, is cut off
+func NewFileHistory(path string) (*FileHistory, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	fh := &FileHistory{
+		file:  file,
+		lines: make(map[string][]fileLine),
+	}
+	if err := fh.index(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read history %s: %w", path, err)
+	}
+	return fh, nil
+}
+
+// index reads the whole file and remembers where each message is
+func (fh *FileHistory) index() error {
+	reader := bufio.NewReader(fh.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// Anything after the last newline was never fully written
+			if len(line) > 0 {
+				if err := fh.file.Truncate(offset); err != nil {
+					return err
+				}
+			}
+			fh.size = offset
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var record fileRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("bad line at offset %d: %w", offset, err)
+		}
+		if want := int64(len(fh.lines[record.Room]) + 1); record.ID != want {
+			return fmt.Errorf("message at offset %d has id %d, expected %d", offset, record.ID, want)
+		}
+		fh.lines[record.Room] = append(fh.lines[record.Room], fileLine{offset: offset, length: len(line)})
+		offset += int64(len(line))
+	}
+}
+
+// Append writes the message to the end of the file
+func (fh *FileHistory) Append(room string, msg NewMessageEvent) (HistoryMessage, error) {
+	fh.Lock()
+	defer fh.Unlock()
+
+	stored := HistoryMessage{ID: int64(len(fh.lines[room]) + 1), NewMessageEvent: msg}
+	data, err := json.Marshal(fileRecord{Room: room, HistoryMessage: stored})
+	if err != nil {
+		return HistoryMessage{}, err
+	}
+	data = append(data, '\n')
+
+	if _, err := fh.file.WriteAt(data, fh.size); err != nil {
+		return HistoryMessage{}, err
+	}
+	fh.lines[room] = append(fh.lines[room], fileLine{offset: fh.size, length: len(data)})
+	fh.size += int64(len(data))
+	return stored, nil
+}
+
+// Before reads up to limit messages older than the before cursor from the file
+func (fh *FileHistory) Before(room string, before int64, limit int) ([]HistoryMessage, error) {
+	fh.Lock()
+	defer fh.Unlock()
+
+	lines := fh.lines[room]
+	end := int64(len(lines))
+	if before > 0 && before-1 < end {
+		end = before - 1
+	}
+	begin := end - int64(limit)
+	if begin < 0 {
+		begin = 0
+	}
+
+	var messages []HistoryMessage
+	for _, line := range lines[begin:end] {
+		data := make([]byte, line.length)
+		if _, err := fh.file.ReadAt(data, line.offset); err != nil {
+			return nil, err
+		}
+		var record fileRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, err
+		}
+		messages = append(messages, record.HistoryMessage)
+	}
+	return messages, nil
+}
+
+// Close closes the history file
+func (fh *FileHistory) Close() error {
+	fh.Lock()
+	defer fh.Unlock()
+
+	return fh.file.Close()
+}
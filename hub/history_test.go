@@ -0,0 +1,223 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// appendMessages appends n messages numbered from 1 to room
+func appendMessages(t *testing.T, store HistoryStore, room string, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		msg := NewMessageEvent{SendMessageEvent: SendMessageEvent{Message: fmt.Sprint(i), From: "percy"}}
+		stored, err := store.Append(room, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.ID != int64(i) {
+			t.Fatalf("expected id %d, got %d", i, stored.ID)
+		}
+	}
+}
+
+// messageIDs returns the ids of messages, it makes failures readable
+func messageIDs(messages []HistoryMessage) []int64 {
+	ids := make([]int64, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+func TestHistoryStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) HistoryStore{
+		"memory": func(t *testing.T) HistoryStore { return NewMemoryHistory(100, 10) },
+		"file": func(t *testing.T) HistoryStore {
+			fh, err := NewFileHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { fh.Close() })
+			return fh
+		},
+	}
+
+	testCases := []struct {
+		name   string
+		before int64
+		limit  int
+		want   string
+	}{
+		{name: "newest", before: 0, limit: 3, want: "[8 9 10]"},
+		{name: "page before cursor", before: 8, limit: 3, want: "[5 6 7]"},
+		{name: "last page is short", before: 3, limit: 3, want: "[1 2]"},
+		{name: "nothing before first", before: 1, limit: 3, want: "[]"},
+		{name: "cursor past the end", before: 50, limit: 2, want: "[9 10]"},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			appendMessages(t, store, "general", 10)
+			appendMessages(t, store, "games", 2)
+
+			for _, tc := range testCases {
+				messages, err := store.Before("general", tc.before, tc.limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := fmt.Sprint(messageIDs(messages)); got != tc.want {
+					t.Errorf("%s: expected %s, got %s", tc.name, tc.want, got)
+				}
+			}
+
+			messages, err := store.Before("games", 0, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != 2 || messages[1].Message != "2" || messages[1].From != "percy" {
+				t.Errorf("rooms should not mix, got %+v", messages)
+			}
+		})
+	}
+}
+
+func TestMemoryHistory_Overwrites(t *testing.T) {
+	mh := NewMemoryHistory(3, 10)
+	appendMessages(t, mh, "general", 5)
+
+	messages, _ := mh.Before("general", 0, 10)
+	if got := fmt.Sprint(messageIDs(messages)); got != "[3 4 5]" {
+		t.Errorf("expected only the newest 3, got %s", got)
+	}
+	messages, _ = mh.Before("general", 3, 10)
+	if len(messages) != 0 {
+		t.Errorf("overwritten messages should be gone, got %v", messageIDs(messages))
+	}
+}
+
+func TestMemoryHistory_ForgetsOldestRoom(t *testing.T) {
+	mh := NewMemoryHistory(3, 2)
+	appendMessages(t, mh, "general", 1)
+	appendMessages(t, mh, "games", 1)
+	// general is busy again, so games is the one to go
+	if _, err := mh.Append("general", NewMessageEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	appendMessages(t, mh, "spam", 1)
+
+	for room, want := range map[string]int{"general": 2, "games": 0, "spam": 1} {
+		if messages, _ := mh.Before(room, 0, 10); len(messages) != want {
+			t.Errorf("expected %d messages in %s, got %d", want, room, len(messages))
+		}
+	}
+	if ring := mh.rooms["spam"]; cap(ring.messages) >= 3 {
+		t.Errorf("expected the ring to grow with the messages, got a capacity of %d", cap(ring.messages))
+	}
+}
+
+func TestMemoryHistory_ZeroCapacity(t *testing.T) {
+	mh := NewMemoryHistory(0, 0)
+	appendMessages(t, mh, "general", 2)
+	appendMessages(t, mh, "games", 1)
+
+	if messages, _ := mh.Before("games", 0, 10); len(messages) != 1 {
+		t.Errorf("expected the last message to be kept, got %d", len(messages))
+	}
+	if messages, _ := mh.Before("general", 0, 10); len(messages) != 0 {
+		t.Errorf("expected general to be forgotten for games, got %d messages", len(messages))
+	}
+}
+
+func TestFileHistory_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	fh, err := NewFileHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendMessages(t, fh, "general", 3)
+	fh.Close()
+
+	// Pretend we crashed in the middle of writing a line
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"room":"general","id":4,"mess`)
+	file.Close()
+
+	fh, err = NewFileHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	stored, err := fh.Append("general", NewMessageEvent{SendMessageEvent: SendMessageEvent{Message: "after restart"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ID != 4 {
+		t.Errorf("expected ids to continue at 4, got %d", stored.ID)
+	}
+	messages, err := fh.Before("general", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(messageIDs(messages)); got != "[1 2 3 4]" || messages[3].Message != "after restart" {
+		t.Errorf("unexpected history after reopen %s", got)
+	}
+}
+
+func TestManager_HistoryOnJoin(t *testing.T) {
+	m, srv := newTestServer(t, WithHistory(NewMemoryHistory(100, 10), 2))
+	percy := connect(t, srv, "percy")
+	waitForClients(t, m, 1)
+
+	for i := 1; i <= 3; i++ {
+		sendEvent(t, percy, EventSendMessage, SendMessageEvent{Message: fmt.Sprint(i)})
+		var msg HistoryMessage
+		readUntil(t, percy, EventNewMessage, &msg)
+		if msg.ID != int64(i) {
+			t.Fatalf("expected new_message to carry id %d, got %d", i, msg.ID)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	history := make(chan HistoryEvent, 1)
+	rc := NewRPCClient(connect(t, srv, "anna"), func(event Event) {
+		if event.Type == EventHistory {
+			var page HistoryEvent
+			if err := event.Payload.Decode(&page); err == nil {
+				history <- page
+			}
+		}
+	})
+	waitForClients(t, m, 2)
+
+	// Moving away and back gives the latest messages of general
+	if err := rc.Call(ctx, EventChangeRoom, ChangeRoomEvent{Name: "games"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-history
+	if err := rc.Call(ctx, EventChangeRoom, ChangeRoomEvent{Name: "general"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	page := <-history
+	if got := fmt.Sprint(messageIDs(page.Messages)); page.Room != "general" || got != "[2 3]" || page.Cursor != 2 {
+		t.Fatalf("unexpected history on join %+v", page)
+	}
+
+	// The cursor pages back to the first message, which is the last page
+	var older HistoryEvent
+	if err := rc.Call(ctx, EventLoadHistory, LoadHistoryRequest{Before: page.Cursor}, &older); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(messageIDs(older.Messages)); got != "[1]" || older.Cursor != 0 {
+		t.Errorf("unexpected older page %+v", older)
+	}
+}
@@ -0,0 +1,540 @@
+package hub
+
+import ( //@进口
+	"context" //@语境
+	"encoding/json" //@编码json
+	"errors" //@错误
+	"log/slog"
+	"net"
+	"net/http" //@净http
+	"strconv"
+	"sync" //@同步
+	"time"
+
+	"github.com/gorilla/websocket" //@github com 大猩猩 websocket
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ( //@变量
+	ErrEventNotSupported = NewHandlerError(ErrCodeUnsupportedEvent, "this event type is not supported")
+)
+
+// checkOrigin will check origin and return true if its allowed //@检查原点将检查原点并在允许的情况下返回 true
+func (m *Manager) checkOrigin(r *http.Request) bool {
+
+	// Grab the request origin //@抓取请求来源
+	origin := r.Header.Get("Origin") //@origin r header 获取原点
+
+	for _, allowed := range m.config.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// Manager is used to hold references to all Clients Registered, and Broadcasting etc //@经理用于保存对所有注册和广播等客户的引用
+type Manager struct { //@类型管理器结构
+	clients ClientList //@客户客户名单
+
+	// Using a syncMutex here to be able to lcok state before editing clients //@在此处使用同步互斥锁，以便能够在编辑客户端之前锁定状态
+	// Could also use Channels to block //@也可以使用通道来阻止
+	sync.RWMutex //@同步读写互斥
+	// config are the settings given to NewManager
+	config Config
+	// logger is where everything about the hub is logged, lines about a client also get its attributes
+	logger *slog.Logger
+	// tracer starts the spans of logins, upgrades, handled events and writes
+	tracer trace.Tracer
+	// upgrader is used to upgrade incomming HTTP requests into a persitent websocket connection
+	upgrader websocket.Upgrader
+	// handlers are functions that are used to handle Events //@处理程序是用于处理事件的函数
+	handlers map[string]EventHandler //@处理程序映射字符串事件处理程序
+	// middleware wraps every handler, eventMiddleware only the handler of a event type
+	middleware      []Middleware
+	eventMiddleware map[string][]Middleware
+	// chains are the handlers wrapped in their middleware, rebuilt whenever a handler or middleware is added
+	chains map[string]EventHandler
+	// rpcs are the event types registered with RegisterRPC
+	rpcs map[string]bool
+	// handlerMu guards handlers, the middleware, chains and rpcs
+	handlerMu sync.RWMutex
+	// otps is a map of allowed OTP to accept connections from //@otps 是允许 otp 接受来自的连接的映射
+	otps *RetentionMap //@otps保留地图
+	// auth is used by the login to verify the users credentials
+	auth Authenticator
+	// rooms keeps track of what clients are in each chat room
+	rooms *RoomRegistry
+	// egressMetrics counts how the egress queues are coping, use EgressStats to read it
+	egressMetrics egressMetrics
+	// compressionMetrics counts the bytes written to every client, use CompressionStats to read it
+	compressionMetrics compressionMetrics
+	// metrics are the rest of the counters served by MetricsHandler
+	metrics *hubMetrics
+	// limiter enforces the rate limits of the users, the same for all their clients
+	limiter *rateLimiter
+	// sessions are the resumable sessions created by the login, by token
+	sessions sessionRegistry
+
+	// ctx is cancelled by Shutdown, goroutines started by the manager stop with it
+	ctx    context.Context
+	cancel context.CancelFunc
+	// closing is set by Shutdown, no new clients are accepted after that
+	closing bool
+	// writers tracks the running writeMessages goroutines so Shutdown can wait for them to flush
+	writers sync.WaitGroup
+}
+
+// NewManager is used to initalize all the values inside the manager //@new manager 用于初始化 manager 中的所有值
+// It starts from DefaultConfig, use the options to change it. The config is
+// validated once every option is applied, a invalid config returns the error of Config.Validate
+func NewManager(ctx context.Context, auth Authenticator, opts ...Option) (*Manager, error) {
+	cfg := DefaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.History == nil {
+		cfg.History = NewMemoryHistory(DefaultHistoryCapacity, DefaultHistoryRooms)
+	}
+	if cfg.RoomStore == nil {
+		cfg.RoomStore = NewMemoryRoomStore()
+	}
+	if cfg.DirectHistory == nil {
+		cfg.DirectHistory = NewMemoryHistory(DefaultHistoryCapacity, DefaultHistoryRooms)
+	}
+	if cfg.Blocks == nil {
+		cfg.Blocks = NewMemoryBlockList()
+	}
+	if cfg.Codecs == nil {
+		cfg.Codecs = DefaultCodecs()
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if cfg.TracerProvider == nil {
+		cfg.TracerProvider = otel.GetTracerProvider()
+	}
+	// Shutdown stops the retention goroutine, even when the callers ctx lives on
+	ctx, cancel := context.WithCancel(ctx)
+
+	m := &Manager{ //@经理
+		config:   cfg,
+		logger:   cfg.Logger,
+		tracer:   cfg.TracerProvider.Tracer(tracerName),
+		auth:     auth,
+		rooms:    NewRoomRegistry(),
+		clients:  make(ClientList), //@客户制作客户名单
+		handlers: make(map[string]EventHandler), //@处理程序使映射字符串事件处理程序
+		rpcs:     make(map[string]bool),
+		chains:   make(map[string]EventHandler),
+		ctx:      ctx,
+		cancel:   cancel,
+		// Middleware is added with Use and UseFor
+		eventMiddleware: make(map[string][]Middleware),
+		// Create a new retentionMap that removes Otps once they expire
+		otps:    NewRetentionMap(ctx, cfg.OTPTTL),
+		metrics: newHubMetrics(),
+		limiter: newRateLimiter(cfg.RateLimit),
+	}
+	m.sessions.sessions = make(map[string]*session)
+	m.upgrader = websocket.Upgrader{
+		// Apply the Origin Checker //@应用原点检查器
+		CheckOrigin:     m.checkOrigin,
+		ReadBufferSize:  cfg.ReadBufferSize,
+		WriteBufferSize: cfg.WriteBufferSize,
+		// Only used with clients that ask for it
+		EnableCompression: cfg.Compression.Enabled,
+	}
+	m.setupEventHandlers() //@m 设置事件处理程序
+	return m, nil //@返回米
+}
+
+// setupEventHandlers configures and adds all handlers //@设置事件处理程序配置并添加所有处理程序
+func (m *Manager) setupEventHandlers() { //@func m 管理器设置事件处理程序
+	m.RegisterHandler(EventSendMessage, SendMessageHandler)
+	m.RegisterHandler(EventTypingStart, TypingStartHandler)
+	m.RegisterHandler(EventTypingStop, TypingStopHandler)
+	m.RegisterHandler(EventSendDirect, SendDirectHandler)
+	m.RegisterHandler(EventChangeRoom, ChatRoomHandler)
+	m.RegisterRPC(EventListRooms, ListRoomsHandler)
+	m.RegisterRPC(EventLoadHistory, LoadHistoryHandler)
+	m.RegisterRPC(EventLoadDirectHistory, LoadDirectHistoryHandler)
+	m.RegisterRPC(EventBlockUser, BlockUserHandler)
+	m.RegisterRPC(EventUnblockUser, UnblockUserHandler)
+	m.RegisterRPC(EventListMembers, ListMembersHandler)
+}
+
+// RegisterHandler adds or replaces the handler for eventType.
+// Handlers has to be registered before the manager starts serving
+func (m *Manager) RegisterHandler(eventType string, handler EventHandler) {
+	m.handlerMu.Lock()
+	defer m.handlerMu.Unlock()
+
+	delete(m.rpcs, eventType)
+	m.handlers[eventType] = handler
+	m.rebuildChain(eventType)
+}
+
+// routeEvent is used to make sure the correct event goes into the correct handler //@路由事件用于确保正确的事件进入正确的处理程序
+func (m *Manager) routeEvent(event Event, c *Client) error { //@func m manager route event event event c 客户端错误
+	// Check if Handler is present in Map //@检查地图中是否存在处理程序
+	if handler, ok := m.chained(event.Type); ok { //@如果处理程序正常 m 处理程序事件类型正常
+		// Execute the handler wrapped in its middleware and return any err
+		started := time.Now()
+		err := handler(event, c)
+		// A RPC handler is only started here, rpcEventHandler times the call
+		if !m.isRPC(event.Type) {
+			m.observeHandler(event.Type, started)
+		}
+		if err != nil {
+			return err //@返回错误
+		}
+		return nil //@返回零
+	} else { //@别的
+		return ErrEventNotSupported //@不支持返回错误事件
+	}
+}
+
+// LoginHandler is used to verify an user authentication and return a one time password
+// Mount it on any route, the frontend expects /login
+func (m *Manager) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	span := m.startHTTPSpan(r, "login")
+	defer span.End()
+
+	type userLoginRequest struct { //@输入用户登录请求结构
+		Username string `json:"username"` //@用户名字符串 json 用户名
+		Password string `json:"password"` //@密码字符串 json 密码
+	}
+
+	var req userLoginRequest //@var req 用户登录请求
+	err := json.NewDecoder(r.Body).Decode(&req) //@错误 json 新解码器 r 主体解码请求
+	if err != nil { //@如果错误为零
+		m.loginFailed(span, "bad_request")
+		writeJSONError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return //@返回
+	}
+	span.SetAttributes(attribute.String("user", req.Username))
+
+	// Authenticate user using the configured backend
+	identity, err := m.authenticate(r, req.Username, req.Password)
+	switch {
+	case errors.Is(err, ErrInvalidCredentials):
+		m.loginFailed(span, "invalid_credentials")
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", err.Error())
+		return
+	case errors.Is(err, ErrTooManyAttempts):
+		m.loginFailed(span, "too_many_attempts")
+		writeJSONError(w, http.StatusTooManyRequests, "too_many_attempts", err.Error())
+		return
+	case err != nil:
+		m.loginFailed(span, "error")
+		m.logger.Error("failed to authenticate", "user", req.Username, "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "failed to authenticate")
+		return
+	}
+
+	// format to return otp in to the frontend //@将 otp 返回到前端的格式
+	type response struct { //@类型响应结构
+		OTP string `json:"otp"` //@otp 字符串 json otp
+		// Session is used to resume the session with /ws?session=...&last_seq=...
+		Session string `json:"session"`
+	}
+
+	// The session outlives the connection, so a client can resume it after losing the connection
+	session, err := m.newSession(identity)
+	if err != nil {
+		m.logger.Error("failed to create session", "user", identity.Username, "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "failed to create session")
+		return
+	}
+	// add a new OTP bound to the user, so the websocket knows who connected
+	otp := m.otps.newOTP(identity, session.token)
+
+	resp := response{ //@响应响应
+		OTP:     otp.Key,
+		Session: session.token,
+	}
+
+	data, err := json.Marshal(resp) //@数据错误 json marshal resp
+	if err != nil { //@如果错误为零
+		m.logger.Error("failed to encode login response", "user", identity.Username, "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "failed to create otp")
+		return //@返回
+	}
+	// Return a response to the Authenticated user with the OTP //@使用 otp 向经过身份验证的用户返回响应
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK) //@w 写入标头 http 状态正常
+	w.Write(data) //@w写数据
+}
+
+// writeJSONError responds with status and a JSON body describing the error
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	type errorResponse struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Code: code, Message: message})
+}
+
+// authenticate verifies the credentials sent in r, with the address of the client if the authenticator wants it
+func (m *Manager) authenticate(r *http.Request, username, password string) (Identity, error) {
+	remote, ok := m.auth.(RemoteAuthenticator)
+	if !ok {
+		return m.auth.Authenticate(username, password)
+	}
+	// Only the host, the port changes with every connection
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return remote.AuthenticateFrom(host, username, password)
+}
+
+// ServeWS is a HTTP Handler that the has the Manager that allows connections
+// Mount it on any route, the frontend expects /ws
+func (m *Manager) ServeWS(w http.ResponseWriter, r *http.Request) {
+	span := m.startHTTPSpan(r, "upgrade")
+	defer span.End()
+
+	if m.isClosing() {
+		m.upgradeFailed(span, "shutting_down")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	// A client that lost its connection resumes its session, everyone else has to bring a OTP
+	var (
+		s       *session
+		resume  bool
+		lastSeq uint64
+	)
+	if token := query.Get("session"); token != "" {
+		var ok bool
+		if s, ok = m.session(token); !ok {
+			m.upgradeFailed(span, "unknown_session")
+			writeJSONError(w, http.StatusUnauthorized, "unknown_session", ErrUnknownSession.Error())
+			return
+		}
+		if raw := query.Get("last_seq"); raw != "" {
+			var err error
+			if lastSeq, err = strconv.ParseUint(raw, 10, 64); err != nil {
+				m.upgradeFailed(span, "bad_request")
+				writeJSONError(w, http.StatusBadRequest, "bad_request", "last_seq has to be a positive number")
+				return
+			}
+		}
+		resume = true
+	} else {
+		// Grab the OTP in the Get param //@获取 get 参数中的 otp
+		otp := query.Get("otp")
+		if otp == "" { //@如果 otp
+			m.upgradeFailed(span, "missing_otp")
+			// Tell the user its not authorized //@告诉用户它没有被授权
+			w.WriteHeader(http.StatusUnauthorized) //@w 写入标头 http 状态未经授权
+			return //@返回
+		}
+
+		// Verify OTP is existing and grab the user it was issued to
+		redeemed, ok := m.otps.Redeem(otp)
+		if !ok {
+			m.upgradeFailed(span, "invalid_otp")
+			w.WriteHeader(http.StatusUnauthorized) //@w 写入标头 http 状态未经授权
+			return //@返回
+		}
+		if s, ok = m.session(redeemed.Session); !ok {
+			m.upgradeFailed(span, "unknown_session")
+			writeJSONError(w, http.StatusUnauthorized, "unknown_session", ErrUnknownSession.Error())
+			return
+		}
+	}
+
+	// Begin by upgrading the HTTP request //@首先升级 http 请求
+	responseHeader := http.Header{}
+	codec := m.selectCodec(r, responseHeader)
+	// Count what is written to the network, to see what compression saves
+	wire := &countingResponseWriter{ResponseWriter: w}
+	conn, err := m.upgrader.Upgrade(wire, r, responseHeader)
+	if err != nil { //@如果错误为零
+		// The upgrader has already answered, a bad handshake or a origin that is not allowed
+		m.upgradeFailed(span, "handshake")
+		m.logger.Warn("failed to upgrade", "user", s.identity.Username, "remote", r.RemoteAddr, "err", err)
+		return //@返回
+	}
+
+	// Create New Client //@创建新客户
+	client := NewClient(conn, m, s.identity)
+	client.session = s
+	client.codec = codec
+	client.wire = wire.conn
+	// The handshake has been written, count the frames from here
+	client.wire.framed = true
+	if m.config.Compression.Enabled && offersCompression(r) {
+		client.compress = true
+		// The level is validated with the config, this can not fail
+		conn.SetCompressionLevel(m.config.Compression.Level)
+	}
+	// Add the newly created client to the manager //@将新创建的客户端添加到管理器
+	if err := m.addClient(client, resume, lastSeq); err != nil {
+		// Shutdown started while we were upgrading, or the session expired
+		code, reason, failure := websocket.CloseGoingAway, shutdownReason, "shutting_down"
+		if errors.Is(err, ErrUnknownSession) {
+			code, reason, failure = websocket.ClosePolicyViolation, err.Error(), "unknown_session"
+		}
+		m.upgradeFailed(span, failure)
+		client.connection.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+		client.connection.Close()
+		return
+	}
+	client.log(slog.LevelInfo, "connected", "resumed", resume, "codec", codec.Subprotocol(), "compression", client.compress)
+	span.SetAttributes(
+		attribute.String("user", client.identity.Username),
+		attribute.String("conn", client.id),
+		attribute.Bool("resumed", resume),
+		attribute.String("codec", codec.Subprotocol()),
+	)
+
+	go client.readMessages() //@去客户端读取消息
+	go client.writeMessages() //@去客户端写消息
+}
+
+// addClient will add clients to our clientList //@添加客户会将客户添加到我们的客户列表中
+// The client takes over its session, when resuming it replaces the previous client in its room
+// and gets the events after lastSeq replayed
+func (m *Manager) addClient(client *Client, resume bool, lastSeq uint64) error {
+	// Look up the room before locking, the store may be slow
+	room := m.lastRoom(client.identity.Username)
+
+	// Tell the room once the lock is released, sending can block on slow clients
+	var changes []presenceChange
+	joined := false
+	defer func() {
+		if joined {
+			client.sendPresence(room)
+		}
+		m.announce(changes)
+	}()
+
+	// Lock so we can manipulate //@锁定以便我们可以操作
+	m.Lock() //@米锁
+	defer m.Unlock() //@延迟解锁
+
+	if m.closing {
+		return errShuttingDown
+	}
+
+	if client.session != nil {
+		previous, replay, gap, err := client.session.attach(client, resume, lastSeq)
+		if err != nil {
+			return err
+		}
+		if resume {
+			resumed := NewPayload(SessionResumedEvent{LastSeq: lastSeq, Replayed: len(replay), Gap: gap})
+			client.replay = append([]Event{{Type: EventSessionResumed, Payload: resumed}}, replay...)
+		}
+		if previous != nil {
+			// A connection we still thought was alive, the client knows better
+			previous.close(websocket.CloseNormalClosure, "session resumed on a new connection")
+			if m.rooms.Replace(previous, client) {
+				room = ""
+			}
+		}
+	}
+
+	// Add Client //@添加客户
+	m.clients[client] = true //@m 客户 客户 真
+	// Users come back to the room they were in, everyone else starts in the default room
+	if room != "" {
+		_, changes = m.rooms.join(client, room)
+		joined = true
+	}
+	// Added under the lock so Shutdown never waits while a writer is being added
+	m.writers.Add(1)
+	return nil
+}
+
+// lastRoom returns the room the user was last in, or DefaultRoom
+func (m *Manager) lastRoom(username string) string {
+	room, ok, err := m.config.RoomStore.Membership(username)
+	if err != nil {
+		m.logger.Error("failed to look up the last room", "user", username, "err", err)
+		return DefaultRoom
+	}
+	if !ok {
+		return DefaultRoom
+	}
+	return room
+}
+
+// removeClient will remove the client and clean up //@删除客户端将删除客户端并清理
+func (m *Manager) removeClient(client *Client) { //@func m manager 删除客户客户客户
+	var changes []presenceChange
+	defer func() {
+		// Nobody is typing on a closed connection
+		client.stopTyping()
+		m.announce(changes)
+	}()
+
+	m.Lock() //@米锁
+	defer m.Unlock() //@延迟解锁
+
+	// Check if Client exists, then delete it //@检查客户端是否存在然后将其删除
+	if _, ok := m.clients[client]; ok { //@如果没问题 m 客户 客户没问题
+		client.log(slog.LevelInfo, "disconnected")
+		// Stop anyone from queueing more events and let the writer exit
+		client.close(websocket.CloseNormalClosure, "")
+		// close connection //@紧密联系
+		client.connection.Close() //@客户端连接关闭
+		// remove //@消除
+		delete(m.clients, client) //@删除 m 个客户 client
+		// While the session can be resumed the client stays in its room, so the session
+		// keeps buffering what is said there. It leaves once the session expires
+		if client.session == nil || !client.session.detach(client, m.config.SessionTTL, func() { m.expireSession(client.session) }) {
+			_, changes = m.rooms.leaveRoom(client)
+		}
+	}
+}
+
+// ClientCount returns how many clients are connected
+func (m *Manager) ClientCount() int {
+	m.RLock()
+	defer m.RUnlock()
+
+	return len(m.clients)
+}
+
+// Broadcast sends the event to every client in the room, it can be used by
+// server side code to push events without a client triggering it
+func (m *Manager) Broadcast(room string, event Event) {
+	// Members is a copy, so no lock is held while waiting on slow clients
+	for _, client := range m.rooms.Members(room) {
+		client.Send(event)
+	}
+}
+
+// broadcastOthers sends the event to every client in the room that does not belong to username
+func (m *Manager) broadcastOthers(room, username string, event Event) {
+	for _, client := range m.rooms.Members(room) {
+		if client.identity.Username != username {
+			client.Send(event)
+		}
+	}
+}
+
+// EgressStats returns counters of dropped events and slow clients
+func (m *Manager) EgressStats() EgressStats {
+	return m.egressMetrics.snapshot()
+}
+
+// CompressionStats returns the bytes written to every client so far before and after compression
+func (m *Manager) CompressionStats() CompressionStats {
+	return m.compressionMetrics.snapshot()
+}
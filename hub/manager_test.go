@@ -0,0 +1,273 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer starts a HTTP server with the manager routes mounted, the users
+// percy and anna can login with the password 123
+func newTestServer(t *testing.T, opts ...Option) (*Manager, *httptest.Server) {
+	t.Helper()
+	auth := NewMemoryAuthenticator()
+	auth.AddUser("percy", "123")
+	auth.AddUser("anna", "123")
+	return newTestServerWithAuth(t, auth, opts...)
+}
+
+// newTestServerWithAuth starts a HTTP server using auth to verify logins
+func newTestServerWithAuth(t *testing.T, auth Authenticator, opts ...Option) (*Manager, *httptest.Server) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	m, err := NewManager(ctx, auth, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", m.LoginHandler)
+	mux.HandleFunc("/ws", m.ServeWS)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return m, srv
+}
+
+// login posts the credentials and returns the OTP
+func login(t *testing.T, srv *httptest.Server, username, password string) (string, int) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"username": username, "password": password})
+	resp, err := http.Post(srv.URL+"/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Error(err)
+		return "", 0
+	}
+	defer resp.Body.Close()
+
+	var data struct {
+		OTP string `json:"otp"`
+	}
+	json.NewDecoder(resp.Body).Decode(&data)
+	return data.OTP, resp.StatusCode
+}
+
+// dial opens a websocket to the test server using the otp
+func dial(srv *httptest.Server, otp string) (*websocket.Conn, *http.Response, error) {
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?otp=" + otp
+	header := http.Header{}
+	header.Set("Origin", "https://localhost:8080")
+	return websocket.DefaultDialer.Dial(url, header)
+}
+
+// connect logs in as username and opens a websocket
+func connect(t *testing.T, srv *httptest.Server, username string) *websocket.Conn {
+	t.Helper()
+	otp, status := login(t, srv, username, "123")
+	if status != http.StatusOK {
+		t.Fatalf("failed to login as %s: %d", username, status)
+	}
+	conn, _, err := dial(srv, otp)
+	if err != nil {
+		t.Fatalf("failed to connect as %s: %v", username, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// waitFor polls until cond is true or fails the test after a while
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+// waitForClients blocks until the manager has n clients registered
+func waitForClients(t *testing.T, m *Manager, n int) {
+	t.Helper()
+	waitFor(t, "clients to register", func() bool {
+		m.RLock()
+		defer m.RUnlock()
+		return len(m.clients) == n
+	})
+}
+
+// sendEvent writes a event with payload to the websocket
+func sendEvent(t *testing.T, conn *websocket.Conn, eventType string, payload any) {
+	t.Helper()
+	call(t, conn, "", eventType, payload)
+}
+
+// call writes a event with a id and payload, the reply is read by the caller
+func call(t *testing.T, conn *websocket.Conn, id, eventType string, payload any) {
+	t.Helper()
+	if err := conn.WriteJSON(Event{Type: eventType, ID: id, Payload: NewPayload(payload)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readEvent reads the next event from the websocket
+func readEvent(t *testing.T, conn *websocket.Conn) Event {
+	t.Helper()
+	for {
+		event := readAnyEvent(t, conn)
+		// Presence is sent whenever someone comes and goes, most tests do not care
+		switch event.Type {
+		case EventUserJoined, EventUserLeft, EventPresenceSnapshot:
+			continue
+		}
+		return event
+	}
+}
+
+// readUntil reads events until one of eventType and decodes its payload into v if v is not nil.
+// Any other event is skipped, but one of unwanted showing up first fails the test
+func readUntil(t *testing.T, conn *websocket.Conn, eventType string, v any, unwanted ...string) Event {
+	t.Helper()
+	for {
+		event := readAnyEvent(t, conn)
+		switch {
+		case event.Type == eventType:
+			if v != nil {
+				if err := event.Payload.Decode(v); err != nil {
+					t.Fatalf("failed to decode %s: %v", eventType, err)
+				}
+			}
+			return event
+		case slices.Contains(unwanted, event.Type):
+			t.Fatalf("expected no %s before %s, got %s", event.Type, eventType, event.Payload)
+		}
+	}
+}
+
+// readAnyEvent reads the next event, including presence events
+func readAnyEvent(t *testing.T, conn *websocket.Conn) Event {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var event Event
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatalf("failed to read event: %v", err)
+	}
+	return event
+}
+
+// TestManager_ConcurrentLoginAndConnect hammers the login and websocket upgrade
+// in parallel, run it with -race to catch unsafe access to the OTPs
+func TestManager_ConcurrentLoginAndConnect(t *testing.T) {
+	m, srv := newTestServer(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				otp, status := login(t, srv, "percy", "123")
+				if status != http.StatusOK {
+					t.Errorf("expected login to succeed, got %d", status)
+					return
+				}
+				conn, _, err := dial(srv, otp)
+				if err != nil {
+					t.Errorf("failed to connect: %v", err)
+					return
+				}
+				conn.Close()
+
+				// A used OTP must never open a second connection
+				if _, resp, err := dial(srv, otp); err == nil {
+					t.Error("reusing a OTP should fail")
+				} else if resp != nil && resp.StatusCode != http.StatusUnauthorized {
+					t.Errorf("expected %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if m.otps.Len() != 0 {
+		t.Errorf("expected all OTPs to be used, %d left", m.otps.Len())
+	}
+}
+
+// failingAuthenticator is a backend that is always broken
+type failingAuthenticator struct{}
+
+func (failingAuthenticator) Authenticate(string, string) (Identity, error) {
+	return Identity{}, errors.New("database is down")
+}
+
+func TestManager_LoginResponses(t *testing.T) {
+	memory := NewMemoryAuthenticator()
+	memory.AddUser("percy", "123")
+
+	testCases := []struct {
+		name     string
+		auth     Authenticator
+		attempts []string
+		status   int
+		code     string
+	}{
+		{name: "valid", auth: memory, attempts: []string{"123"}, status: http.StatusOK},
+		{name: "wrong password", auth: memory, attempts: []string{"wrong"}, status: http.StatusUnauthorized, code: "unauthorized"},
+		{name: "locked out", auth: NewLockoutAuthenticator(memory, 2, time.Minute), attempts: []string{"wrong", "wrong", "123"}, status: http.StatusTooManyRequests, code: "too_many_attempts"},
+		{name: "broken backend", auth: failingAuthenticator{}, attempts: []string{"123"}, status: http.StatusInternalServerError, code: "internal_error"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, srv := newTestServerWithAuth(t, tc.auth)
+
+			var resp *http.Response
+			for _, password := range tc.attempts {
+				body, _ := json.Marshal(map[string]string{"username": "percy", "password": password})
+				var err error
+				resp, err = http.Post(srv.URL+"/login", "application/json", bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if password != tc.attempts[len(tc.attempts)-1] {
+					resp.Body.Close()
+				}
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, resp.StatusCode)
+			}
+			if resp.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("expected a JSON response, got %q", resp.Header.Get("Content-Type"))
+			}
+			if tc.code == "" {
+				return
+			}
+			var errResp struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+				t.Fatal(err)
+			}
+			if errResp.Code != tc.code || errResp.Message == "" {
+				t.Errorf("unexpected error body %+v", errResp)
+			}
+		})
+	}
+}
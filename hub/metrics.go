@@ -0,0 +1,248 @@
+package hub
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultLatencyBuckets are the upper bounds in seconds of the handler latency histogram
+var DefaultLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+const (
+	// unknownEventType is the label used for events without a handler, so clients can not make up label values
+	unknownEventType = "unknown"
+	// invalidEventType is the label used for messages that could not be decoded into a event
+	invalidEventType = "invalid"
+)
+
+// hubMetrics are the counters behind MetricsHandler that are not kept anywhere else
+type hubMetrics struct {
+	eventsReceived counterVec
+	eventsSent     counterVec
+	handlerLatency histogramVec
+	loginFailures  counterVec
+	upgradeErrors  counterVec
+	rateLimited    counterVec
+}
+
+func newHubMetrics() *hubMetrics {
+	return &hubMetrics{
+		handlerLatency: histogramVec{buckets: DefaultLatencyBuckets},
+	}
+}
+
+// counterVec is a counter per label value
+type counterVec struct {
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+func (cv *counterVec) inc(label string) {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	if cv.values == nil {
+		cv.values = make(map[string]int64)
+	}
+	cv.values[label]++
+}
+
+// snapshot returns a copy of the counters
+func (cv *counterVec) snapshot() map[string]int64 {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	values := make(map[string]int64, len(cv.values))
+	for label, value := range cv.values {
+		values[label] = value
+	}
+	return values
+}
+
+// histogram counts observations into buckets, counts[i] is the observations up to buckets[i]
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// histogramVec is a histogram per label value
+type histogramVec struct {
+	mu      sync.Mutex
+	buckets []float64
+	series  map[string]*histogram
+}
+
+func (hv *histogramVec) observe(label string, value float64) {
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	if hv.series == nil {
+		hv.series = make(map[string]*histogram)
+	}
+	h, ok := hv.series[label]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(hv.buckets))}
+		hv.series[label] = h
+	}
+	for i, bound := range hv.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// snapshot returns a copy of the histograms
+func (hv *histogramVec) snapshot() map[string]histogram {
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	series := make(map[string]histogram, len(hv.series))
+	for label, h := range hv.series {
+		series[label] = histogram{counts: append([]uint64(nil), h.counts...), count: h.count, sum: h.sum}
+	}
+	return series
+}
+
+// eventLabel is the event type used as a label, types without a handler are all counted as unknown
+func (m *Manager) eventLabel(eventType string) string {
+	m.handlerMu.RLock()
+	defer m.handlerMu.RUnlock()
+	if _, ok := m.handlers[eventType]; ok {
+		return eventType
+	}
+	return unknownEventType
+}
+
+// observeHandler records how long the handler of eventType took
+func (m *Manager) observeHandler(eventType string, started time.Time) {
+	m.metrics.handlerLatency.observe(eventType, time.Since(started).Seconds())
+}
+
+// upgradeFailed counts a websocket that could not be opened and marks the span of the upgrade as failed
+func (m *Manager) upgradeFailed(span trace.Span, reason string) {
+	m.metrics.upgradeErrors.inc(reason)
+	span.SetStatus(codes.Error, reason)
+}
+
+// loginFailed counts a failed login and marks the span of the login as failed
+func (m *Manager) loginFailed(span trace.Span, reason string) {
+	m.metrics.loginFailures.inc(reason)
+	span.SetStatus(codes.Error, reason)
+}
+
+// MetricsHandler writes the metrics of the hub in the Prometheus text format.
+// Mount it on any route, Prometheus expects /metrics. The compression bytes are also written per
+// connection, labeled with the conn id listed by the admin API. Only open connections has a series,
+// so there are never more of them than connections
+func (m *Manager) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	m.RLock()
+	connections := len(m.clients)
+	var queued, maxQueued int
+	connBefore := make(map[string]int64, len(m.clients))
+	connAfter := make(map[string]int64, len(m.clients))
+	for client := range m.clients {
+		depth := len(client.egress)
+		queued += depth
+		if depth > maxQueued {
+			maxQueued = depth
+		}
+		stats := client.CompressionStats()
+		connBefore[client.id] = stats.BytesBefore
+		connAfter[client.id] = stats.BytesAfter
+	}
+	m.RUnlock()
+
+	egress := m.EgressStats()
+	compression := m.CompressionStats()
+	otps := m.otps.Stats()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	mw := metricsWriter{w: bufio.NewWriter(w)}
+	mw.gauge("websockets_connections", "Open websocket connections.", int64(connections))
+	mw.gauge("websockets_rooms", "Rooms with at least one member.", int64(len(m.rooms.Rooms())))
+	mw.counterVec("websockets_events_received_total", "Events received from clients by type.", "type", m.metrics.eventsReceived.snapshot())
+	mw.counterVec("websockets_events_sent_total", "Events written to clients by type.", "type", m.metrics.eventsSent.snapshot())
+	mw.histogramVec("websockets_handler_duration_seconds", "How long event handlers take by type.", "type",
+		m.metrics.handlerLatency.buckets, m.metrics.handlerLatency.snapshot())
+	mw.gauge("websockets_egress_queue_depth", "Events waiting in the egress queues of all clients.", int64(queued))
+	mw.gauge("websockets_egress_queue_depth_max", "Events waiting in the fullest egress queue.", int64(maxQueued))
+	mw.counter("websockets_egress_dropped_total", "Events dropped because a egress queue was full.", egress.Dropped)
+	mw.counter("websockets_egress_slow_consumers_total", "Times a client was marked as slow.", egress.SlowConsumers)
+	mw.counter("websockets_egress_disconnected_total", "Clients disconnected for being slow.", egress.Disconnected)
+	mw.counter("websockets_otps_issued_total", "OTPs handed out by the login.", otps.Issued)
+	mw.counter("websockets_otps_verified_total", "OTPs used to open a websocket.", otps.Verified)
+	mw.counter("websockets_otps_expired_total", "OTPs that expired without being used.", otps.Expired)
+	mw.counterVec("websockets_login_failures_total", "Failed logins by reason.", "reason", m.metrics.loginFailures.snapshot())
+	mw.counterVec("websockets_upgrade_errors_total", "Websockets that could not be opened by reason.", "reason", m.metrics.upgradeErrors.snapshot())
+	mw.counterVec("websockets_rate_limited_total", "Events dropped for going over a rate limit by type, unknown when dropped before decoding.", "type", m.metrics.rateLimited.snapshot())
+	mw.counter("websockets_compression_messages_total", "Messages written to clients.", compression.Messages)
+	mw.counter("websockets_compression_compressed_total", "Messages written to clients compressed.", compression.Compressed)
+	mw.counter("websockets_compression_bytes_before_total", "Bytes of the messages written before compression.", compression.BytesBefore)
+	mw.counter("websockets_compression_bytes_after_total", "Bytes written to the network for the messages.", compression.BytesAfter)
+	mw.counterVec("websockets_connection_compression_bytes_before_total", "Bytes of the messages written before compression by open connection.", "conn", connBefore)
+	mw.counterVec("websockets_connection_compression_bytes_after_total", "Bytes written to the network for the messages by open connection.", "conn", connAfter)
+	if err := mw.w.Flush(); err != nil {
+		m.logger.Debug("failed to write metrics", "err", err)
+	}
+}
+
+// metricsWriter writes metrics in the Prometheus text format
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+func (mw metricsWriter) header(name, help, kind string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (mw metricsWriter) gauge(name, help string, value int64) {
+	mw.header(name, help, "gauge")
+	fmt.Fprintf(mw.w, "%s %d\n", name, value)
+}
+
+func (mw metricsWriter) counter(name, help string, value int64) {
+	mw.header(name, help, "counter")
+	fmt.Fprintf(mw.w, "%s %d\n", name, value)
+}
+
+func (mw metricsWriter) counterVec(name, help, label string, values map[string]int64) {
+	mw.header(name, help, "counter")
+	for _, value := range sortedKeys(values) {
+		fmt.Fprintf(mw.w, "%s{%s=\"%s\"} %d\n", name, label, labelEscaper.Replace(value), values[value])
+	}
+}
+
+func (mw metricsWriter) histogramVec(name, help, label string, buckets []float64, series map[string]histogram) {
+	mw.header(name, help, "histogram")
+	for _, value := range sortedKeys(series) {
+		h := series[value]
+		labels := fmt.Sprintf("%s=\"%s\"", label, labelEscaper.Replace(value))
+		for i, bound := range buckets {
+			fmt.Fprintf(mw.w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(mw.w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(mw.w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(mw.w, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+}
+
+// labelEscaper escapes label values the way the text format wants them
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sortedKeys returns the keys of values sorted, so the output is the same every time
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,64 @@
+package hub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHistogramVec_Observe(t *testing.T) {
+	hv := histogramVec{buckets: []float64{0.1, 1}}
+	for _, value := range []float64{0.05, 0.5, 5} {
+		hv.observe("send_message", value)
+	}
+	h := hv.snapshot()["send_message"]
+	if h.counts[0] != 1 || h.counts[1] != 2 || h.count != 3 || h.sum != 5.55 {
+		t.Errorf("unexpected histogram %+v", h)
+	}
+}
+
+// scrape returns the metrics served by m
+func scrape(t *testing.T, m *Manager) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.MetricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	return rec.Body.String()
+}
+
+func TestManager_Metrics(t *testing.T) {
+	m, srv := newTestServer(t)
+
+	login(t, srv, "percy", "wrong")
+	if _, resp, err := dial(srv, "made-up"); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected the made up otp to be refused, got %v", err)
+	}
+	conn := connect(t, srv, "percy")
+	waitForClients(t, m, 1)
+	sendEvent(t, conn, EventSendMessage, SendMessageEvent{Message: "hello"})
+	sendEvent(t, conn, "made_up", nil)
+	readUntil(t, conn, EventError, nil)
+
+	metrics := scrape(t, m)
+	for _, want := range []string{
+		"websockets_connections 1\n",
+		"websockets_rooms 1\n",
+		`websockets_events_received_total{type="send_message"} 1` + "\n",
+		`websockets_events_received_total{type="unknown"} 1` + "\n",
+		`websockets_events_sent_total{type="new_message"} 1` + "\n",
+		`websockets_handler_duration_seconds_count{type="send_message"} 1` + "\n",
+		`websockets_handler_duration_seconds_bucket{type="send_message",le="+Inf"} 1` + "\n",
+		"websockets_otps_issued_total 1\n",
+		"websockets_otps_verified_total 1\n",
+		`websockets_login_failures_total{reason="invalid_credentials"} 1` + "\n",
+		`websockets_upgrade_errors_total{reason="invalid_otp"} 1` + "\n",
+		"# TYPE websockets_handler_duration_seconds histogram\n",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("expected %q in\n%s", want, metrics)
+		}
+	}
+}
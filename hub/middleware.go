@@ -0,0 +1,136 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware wraps a EventHandler to add behaviour before or after it runs, like
+// logging, auth checks or metrics, without copying it into every handler
+type Middleware func(next EventHandler) EventHandler
+
+// Use adds middleware that runs for every event type, in the order given.
+// Global middleware runs before middleware added with UseFor.
+// Like the handlers it has to be set up before the manager starts serving
+func (m *Manager) Use(mw ...Middleware) {
+	m.handlerMu.Lock()
+	defer m.handlerMu.Unlock()
+
+	m.middleware = append(m.middleware, mw...)
+	for eventType := range m.handlers {
+		m.rebuildChain(eventType)
+	}
+}
+
+// UseFor adds middleware that only runs for eventType
+func (m *Manager) UseFor(eventType string, mw ...Middleware) {
+	m.handlerMu.Lock()
+	defer m.handlerMu.Unlock()
+
+	m.eventMiddleware[eventType] = append(m.eventMiddleware[eventType], mw...)
+	m.rebuildChain(eventType)
+}
+
+// chained returns the handler of eventType wrapped in its middleware
+func (m *Manager) chained(eventType string) (EventHandler, bool) {
+	m.handlerMu.RLock()
+	defer m.handlerMu.RUnlock()
+
+	handler, ok := m.chains[eventType]
+	return handler, ok
+}
+
+// rebuildChain wraps the handler of eventType in its middleware once, so it is not done for every event.
+// Call it with handlerMu locked whenever the handler or the middleware changes
+func (m *Manager) rebuildChain(eventType string) {
+	handler, ok := m.handlers[eventType]
+	if !ok {
+		// UseFor can be called before the handler is registered
+		return
+	}
+	m.chains[eventType] = m.chain(eventType, handler)
+}
+
+// chain wraps handler in the global and per event middleware, the first middleware added is the outermost
+func (m *Manager) chain(eventType string, handler EventHandler) EventHandler {
+	perEvent := m.eventMiddleware[eventType]
+	for i := len(perEvent) - 1; i >= 0; i-- {
+		handler = perEvent[i](handler)
+	}
+	for i := len(m.middleware) - 1; i >= 0; i-- {
+		handler = m.middleware[i](handler)
+	}
+	return handler
+}
+
+// RecoveryMiddleware turns a panicking handler into a internal error instead of killing the server
+func RecoveryMiddleware() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(event Event, c *Client) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					c.log(slog.LevelError, "panic handling event", "event", event.Type, "panic", r, "stack", string(debug.Stack()))
+					err = &HandlerError{Code: ErrCodeInternal, Message: "internal error", Err: fmt.Errorf("panic: %v", r)}
+				}
+			}()
+			return next(event, c)
+		}
+	}
+}
+
+// TimingMiddleware reports how long each handler took to observe, together with the result
+func TimingMiddleware(observe func(eventType string, duration time.Duration, err error)) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(event Event, c *Client) error {
+			start := time.Now()
+			err := next(event, c)
+			observe(event.Type, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// LoggingMiddleware logs one line per handled event to logger, with the attributes of the client.
+// Leave logger nil to log to the logger of the manager. Failed events are logged as warnings
+func LoggingMiddleware(logger *slog.Logger) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(event Event, c *Client) error {
+			start := time.Now()
+			err := next(event, c)
+
+			to := logger
+			if to == nil {
+				to = c.manager.logger
+			}
+			attrs := append(c.logAttrs(), "event", event.Type, "duration", time.Since(start))
+			if event.ID != "" {
+				attrs = append(attrs, "id", event.ID)
+			}
+			level := slog.LevelInfo
+			if err != nil {
+				attrs = append(attrs, "err", err)
+				level = slog.LevelWarn
+			}
+			to.Log(context.Background(), level, "handled event", attrs...)
+			return err
+		}
+	}
+}
+
+// ErrForbidden is returned by RequireRole when the user is missing the role
+var ErrForbidden = NewHandlerError(ErrCodeForbidden, "you are not allowed to do that")
+
+// RequireRole only lets users that has been granted role through to the handler
+func RequireRole(role string) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(event Event, c *Client) error {
+			if !c.identity.HasRole(role) {
+				return ErrForbidden
+			}
+			return next(event, c)
+		}
+	}
+}
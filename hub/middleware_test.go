@@ -0,0 +1,170 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newHandlerTestManager creates a manager and a client that is never connected,
+// enough to call routeEvent directly
+func newHandlerTestManager(t *testing.T, identity Identity) (*Manager, *Client) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	m, err := NewManager(ctx, NewMemoryAuthenticator())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, NewClient(nil, m, identity)
+}
+
+// recordingMiddleware appends name to calls before and after the handler runs
+func recordingMiddleware(name string, calls *[]string) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(event Event, c *Client) error {
+			*calls = append(*calls, name+" before")
+			err := next(event, c)
+			*calls = append(*calls, name+" after")
+			return err
+		}
+	}
+}
+
+func TestManager_MiddlewareOrder(t *testing.T) {
+	m, c := newHandlerTestManager(t, Identity{Username: "percy"})
+
+	var calls []string
+	m.RegisterHandler("test", func(event Event, c *Client) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+	m.UseFor("test", recordingMiddleware("event", &calls))
+	m.Use(recordingMiddleware("first", &calls), recordingMiddleware("second", &calls))
+	m.UseFor("other", recordingMiddleware("other", &calls))
+
+	if err := m.routeEvent(Event{Type: "test"}, c); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"first before", "second before", "event before", "handler", "event after", "second after", "first after"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestManager_MiddlewareChainIsCached(t *testing.T) {
+	m, c := newHandlerTestManager(t, Identity{Username: "percy"})
+
+	var wraps int
+	counting := func(next EventHandler) EventHandler {
+		wraps++
+		return next
+	}
+	// Middleware added before the handler is registered applies to it as well
+	m.UseFor("test", counting)
+	m.RegisterHandler("test", func(event Event, c *Client) error { return nil })
+	wraps = 0
+
+	for i := 0; i < 3; i++ {
+		if err := m.routeEvent(Event{Type: "test"}, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if wraps != 0 {
+		t.Errorf("expected the chain to be built once, it was built %d more times", wraps)
+	}
+
+	// Adding middleware rebuilds the chain
+	var calls []string
+	m.Use(recordingMiddleware("late", &calls))
+	if err := m.routeEvent(Event{Type: "test"}, c); err != nil {
+		t.Fatal(err)
+	}
+	if wraps != 1 || len(calls) != 2 {
+		t.Errorf("expected the late middleware in the rebuilt chain, got %d builds and %v", wraps, calls)
+	}
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	m, c := newHandlerTestManager(t, Identity{Username: "percy"})
+	m.RegisterHandler("boom", func(event Event, c *Client) error {
+		panic("boom")
+	})
+	m.Use(RecoveryMiddleware())
+
+	err := m.routeEvent(Event{Type: "boom"}, c)
+	var handlerErr *HandlerError
+	if !errors.As(err, &handlerErr) || handlerErr.Code != ErrCodeInternal {
+		t.Errorf("expected a internal error, got %v", err)
+	}
+}
+
+func TestTimingMiddleware(t *testing.T) {
+	m, c := newHandlerTestManager(t, Identity{Username: "percy"})
+	m.RegisterHandler("slow", func(event Event, c *Client) error {
+		time.Sleep(10 * time.Millisecond)
+		return ErrEmptyRoomName
+	})
+
+	var observed time.Duration
+	var observedErr error
+	m.Use(TimingMiddleware(func(eventType string, d time.Duration, err error) {
+		observed, observedErr = d, err
+	}))
+
+	m.routeEvent(Event{Type: "slow"}, c)
+	if observed < 10*time.Millisecond {
+		t.Errorf("expected at least 10ms, got %s", observed)
+	}
+	if observedErr != ErrEmptyRoomName {
+		t.Errorf("expected the handler error to be observed, got %v", observedErr)
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	m, c := newHandlerTestManager(t, Identity{Username: "percy"})
+	m.RegisterHandler("test", func(event Event, c *Client) error {
+		return ErrEmptyRoomName
+	})
+
+	var buf bytes.Buffer
+	m.Use(LoggingMiddleware(slog.New(slog.NewTextHandler(&buf, nil))))
+	m.routeEvent(Event{Type: "test", ID: "7"}, c)
+
+	line := buf.String()
+	for _, field := range []string{"level=WARN", "conn=" + c.ID(), "event=test", "user=percy", "id=7", `err="invalid: room name can not be empty"`} {
+		if !strings.Contains(line, field) {
+			t.Errorf("expected %s in %q", field, line)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	testCases := []struct {
+		name     string
+		identity Identity
+		err      error
+	}{
+		{name: "admin", identity: Identity{Username: "percy", Roles: []string{"admin"}}},
+		{name: "missing role", identity: Identity{Username: "anna"}, err: ErrForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, c := newHandlerTestManager(t, tc.identity)
+			m.RegisterHandler("kick", func(event Event, c *Client) error { return nil })
+			m.UseFor("kick", RequireRole("admin"))
+
+			if err := m.routeEvent(Event{Type: "kick"}, c); err != tc.err {
+				t.Errorf("expected %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
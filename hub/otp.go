@@ -0,0 +1,257 @@
+// The OTP file is used for having a OTP manager
+
+package hub
+
+import ( //@进口
+	"container/heap"
+	"context" //@语境
+	"sync"
+	"sync/atomic"
+	"time" //@时间
+
+	"github.com/google/uuid" //@github com 谷歌 uuid
+)
+
+// OTP is a one time password handed out by the login and used once to open a websocket
+type OTP struct { //@输入 otp 结构
+	Key     string //@关键字符串
+	Created time.Time //@创建时间
+	// Identity is the user that logged in to get the OTP
+	Identity Identity
+	// Session is the token of the session created by the same login
+	Session string
+}
+
+type Verifier interface { //@类型验证器接口
+	VerifyOTP(otp string) bool //@验证 ot p otp string bool
+}
+
+// RetentionMap holds all the OTPs that has been handed out and not yet used.
+// It is safe to use from many goroutines at once, the login handler, the websocket
+// upgrade and the Retention goroutine all share the same instance
+type RetentionMap struct {
+	// mu protects otps and expiry
+	mu sync.Mutex
+	// otps are the currently valid passwords by their key
+	otps map[string]OTP
+	// expiry is a min-heap ordered by expiry time, so the Retention only has to
+	// look at the passwords that are actually about to expire
+	expiry expiryHeap
+	// retentionPeriod is how long a OTP is valid
+	retentionPeriod time.Duration
+	// wake tells the Retention goroutine that a new OTP expires before the one it is waiting for
+	wake chan struct{}
+	// metrics counts what happens to the OTPs, use Stats to read it
+	metrics otpMetrics
+}
+
+// OTPStats are counters of the OTPs handed out and what became of them
+type OTPStats struct {
+	// Issued is the number of OTPs created
+	Issued int64
+	// Verified is the number of OTPs that was used to open a websocket
+	Verified int64
+	// Expired is the number of OTPs that was never used in time
+	Expired int64
+}
+
+// otpMetrics holds the counters behind OTPStats, only use it with sync/atomic
+type otpMetrics struct {
+	issued   int64
+	verified int64
+	expired  int64
+}
+
+// Make sure RetentionMap can be used as a Verifier
+var _ Verifier = (*RetentionMap)(nil)
+
+// NewRetentionMap will create a new retentionmap and start the retention given the set period //@new retention map 将创建一个新的 retentionmap 并在给定的期限内开始保留
+func NewRetentionMap(ctx context.Context, retentionPeriod time.Duration) *RetentionMap {
+	rm := &RetentionMap{
+		otps:            make(map[string]OTP),
+		retentionPeriod: retentionPeriod,
+		wake:            make(chan struct{}, 1),
+	}
+
+	go rm.Retention(ctx)
+
+	return rm //@返回 rm
+}
+
+// NewOTP creates and adds a new otp to the map //@new otp 创建新的 otp 并将其添加到地图
+// The identity is handed back when the OTP is redeemed
+func (rm *RetentionMap) NewOTP(identity Identity) OTP {
+	return rm.newOTP(identity, "")
+}
+
+// newOTP is NewOTP for a login that also created a session
+func (rm *RetentionMap) newOTP(identity Identity, session string) OTP {
+	o := OTP{
+		Key:      uuid.NewString(),
+		Created:  time.Now(),
+		Identity: identity,
+		Session:  session,
+	}
+
+	atomic.AddInt64(&rm.metrics.issued, 1)
+	rm.mu.Lock()
+	rm.otps[o.Key] = o
+	heap.Push(&rm.expiry, expiryItem{key: o.Key, expires: o.Created.Add(rm.retentionPeriod)})
+	// Only bother the Retention if this OTP is now the first one to expire
+	first := rm.expiry[0].key == o.Key
+	rm.mu.Unlock()
+
+	if first {
+		select {
+		case rm.wake <- struct{}{}:
+		default:
+			// A wake up is already pending
+		}
+	}
+	return o //@回车
+}
+
+// VerifyOTP will make sure a OTP exists //@验证 ot p 将确保 otp 存在
+// and return true if so //@如果是，则返回 true
+// It will also delete the key so it cant be reused //@它还会删除密钥，因此无法重复使用
+func (rm *RetentionMap) VerifyOTP(otp string) bool {
+	_, ok := rm.Redeem(otp)
+	return ok
+}
+
+// Redeem works like VerifyOTP but also returns the OTP so the identity behind it can be used
+func (rm *RetentionMap) Redeem(otp string) (OTP, bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	// Verify OTP is existing //@验证 otp 是否存在
+	o, ok := rm.otps[otp]
+	if !ok {
+		// otp does not exist //@otp不存在
+		return OTP{}, false
+	}
+	// The Retention might not have run yet, so never accept an expired password
+	if !o.Created.Add(rm.retentionPeriod).After(time.Now()) {
+		return OTP{}, false
+	}
+	// The heap entry is left behind and skipped once it expires
+	delete(rm.otps, otp)
+	atomic.AddInt64(&rm.metrics.verified, 1)
+	return o, true
+}
+
+// Revoke removes the unused OTPs issued to username, or every unused OTP if username is empty.
+// It returns the removed OTPs, the heap entries are left behind and skipped once they expire
+func (rm *RetentionMap) Revoke(username string) []OTP {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	var revoked []OTP
+	for key, o := range rm.otps {
+		if username == "" || o.Identity.Username == username {
+			delete(rm.otps, key)
+			revoked = append(revoked, o)
+		}
+	}
+	return revoked
+}
+
+// Stats returns how many OTPs has been issued, verified and expired
+func (rm *RetentionMap) Stats() OTPStats {
+	return OTPStats{
+		Issued:   atomic.LoadInt64(&rm.metrics.issued),
+		Verified: atomic.LoadInt64(&rm.metrics.verified),
+		Expired:  atomic.LoadInt64(&rm.metrics.expired),
+	}
+}
+
+// Len returns the amount of OTPs that are still valid
+func (rm *RetentionMap) Len() int {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	return len(rm.otps)
+}
+
+// Get returns the OTP stored under key without consuming it
+func (rm *RetentionMap) Get(key string) (OTP, bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	o, ok := rm.otps[key]
+	return o, ok
+}
+
+// Retention will make sure old OTPs are removed //@保留将确保删除旧的 o tps
+// Is Blocking, so run as a Goroutine //@正在阻塞，所以作为 goroutine 运行
+// Instead of scanning the whole map on an interval it sleeps until the next OTP expires
+func (rm *RetentionMap) Retention(ctx context.Context) {
+	for { //@为了
+		wait, pending := rm.expire(time.Now())
+
+		// Only arm a timer if there is something left to expire
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if pending {
+			timer = time.NewTimer(wait)
+			fire = timer.C
+		}
+
+		select { //@选择
+		case <-fire:
+		case <-rm.wake:
+		case <-ctx.Done(): //@案例 ctx 完成
+			if timer != nil {
+				timer.Stop()
+			}
+			return //@返回
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// expire removes all OTPs that has expired at now, it returns how long until the
+// next OTP expires and false if there is nothing left to wait for
+func (rm *RetentionMap) expire(now time.Time) (time.Duration, bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	for rm.expiry.Len() > 0 {
+		next := rm.expiry[0]
+		if next.expires.After(now) {
+			return next.expires.Sub(now), true
+		}
+		heap.Pop(&rm.expiry)
+		// The OTP might already have been used, then there is nothing to delete
+		if _, ok := rm.otps[next.key]; ok {
+			delete(rm.otps, next.key)
+			atomic.AddInt64(&rm.metrics.expired, 1)
+		}
+	}
+	return 0, false
+}
+
+// expiryItem is a entry in the expiryHeap
+type expiryItem struct {
+	key     string
+	expires time.Time
+}
+
+// expiryHeap implements heap.Interface ordered by the earliest expiry
+type expiryHeap []expiryItem
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x any) { *h = append(*h, x.(expiryItem)) }
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
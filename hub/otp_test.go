@@ -0,0 +1,149 @@
+package hub
+
+import ( //@进口
+	"context" //@语境
+	"sync"
+	"testing" //@测试
+	"time" //@时间
+)
+
+
+func TestRetentionMap_VerifyOTP(t *testing.T) { //@func test retention map verify ot p t 测试 t
+	ctx := context.Background() //@ctx上下文背景
+	ctx, cancel := context.WithCancel(ctx) //@ctx 使用 cancel ctx 取消上下文
+
+
+	rm := NewRetentionMap(ctx, 1*time.Second) //@rm new retention map ctx 时间秒
+
+	otp := rm.NewOTP(Identity{Username: "percy"}) //@otp rm 新的 ot p
+
+	if ok := rm.VerifyOTP(otp.Key); !ok{ //@如果正常 rm 验证 ot p otp 密钥正常
+		t.Error("failed to verify otp key that exists") //@t 错误无法验证存在的 otp 密钥
+	}
+	if ok := rm.VerifyOTP(otp.Key); ok{ //@如果正常 rm 验证 ot p otp 密钥正常
+		t.Error("Reusing a OTP should not succeed") //@重复使用 otp 的错误不应该成功
+	}
+
+
+	cancel() //@取消
+}
+
+func TestOTP_Retention(t *testing.T) { //@功能测试 ot p 保留 t 测试 t
+
+	// Create context with cancel to stop goroutine //@使用 cancel 创建上下文以停止 goroutine
+	ctx := context.Background() //@ctx上下文背景
+	ctx, cancel := context.WithCancel(ctx) //@ctx 使用 cancel ctx 取消上下文
+
+	// Create RM and add a few OTP with a few Seconds in between //@创建 rm 并添加几个 otp，中间间隔几秒钟
+	rm := NewRetentionMap(ctx, 1*time.Second) //@rm new retention map ctx 时间秒
+
+	rm.NewOTP(Identity{Username: "percy"}) //@rm 新 ot p
+	rm.NewOTP(Identity{Username: "percy"}) //@rm 新 ot p
+
+	time.Sleep(2 * time.Second) //@时间睡眠时间秒
+
+	otp := rm.NewOTP(Identity{Username: "percy"}) //@otp rm 新的 ot p
+
+	// Make sure that only 1 password is still left and it matches the latest //@确保只剩下密码并且它与最新的相匹配
+	if rm.Len() != 1 {
+		t.Error("Failed to clean up") //@t错误清理失败
+	}
+
+	if got, ok := rm.Get(otp.Key); !ok || got.Key != otp.Key {
+		t.Error("The key should still be in place") //@t error 钥匙应该还在原位
+	}
+	cancel() //@取消
+}
+
+func TestOTP_VerifyExpiredBeforeRetention(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rm := NewRetentionMap(ctx, 50*time.Millisecond)
+	otp := rm.NewOTP(Identity{Username: "percy"})
+
+	// Stop the Retention so only VerifyOTP can reject the password
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+
+	if rm.VerifyOTP(otp.Key) {
+		t.Error("An expired OTP should not be accepted")
+	}
+}
+
+func TestOTP_RetentionWakesForEarlierExpiry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rm := NewRetentionMap(ctx, 100*time.Millisecond)
+	// Let the Retention go to sleep without anything to wait for
+	time.Sleep(20 * time.Millisecond)
+	rm.NewOTP(Identity{Username: "percy"})
+
+	time.Sleep(300 * time.Millisecond)
+	if rm.Len() != 0 {
+		t.Errorf("Expected the OTP to be expired, still have %d", rm.Len())
+	}
+}
+
+// TestOTP_Concurrent is meant to be run with -race
+func TestOTP_Concurrent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rm := NewRetentionMap(ctx, 20*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				otp := rm.NewOTP(Identity{Username: "percy"})
+				if j%2 == 0 {
+					rm.VerifyOTP(otp.Key)
+				}
+				rm.Len()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Everything that was not verified should be cleaned up by the Retention
+	time.Sleep(100 * time.Millisecond)
+	if rm.Len() != 0 {
+		t.Errorf("Expected all OTPs to be expired, still have %d", rm.Len())
+	}
+}
+
+func TestRetentionMap_RedeemIdentity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rm := NewRetentionMap(ctx, time.Second)
+	otp := rm.NewOTP(Identity{Username: "anna", Roles: []string{"admin"}})
+
+	got, ok := rm.Redeem(otp.Key)
+	if !ok {
+		t.Fatal("failed to redeem otp")
+	}
+	if got.Identity.Username != "anna" || !got.Identity.HasRole("admin") {
+		t.Errorf("expected the identity used to create the otp, got %+v", got.Identity)
+	}
+}
+
+func TestRetentionMap_Stats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rm := NewRetentionMap(ctx, time.Minute)
+	used := rm.NewOTP(Identity{Username: "percy"})
+	rm.NewOTP(Identity{Username: "anna"})
+	rm.VerifyOTP(used.Key)
+	rm.VerifyOTP("made-up")
+	rm.expire(time.Now().Add(time.Hour))
+
+	if stats := rm.Stats(); stats != (OTPStats{Issued: 2, Verified: 1, Expired: 1}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,4 @@
+// Package pb holds the generated protobuf messages of the protobuf subprotocol, the schemas are in events.proto
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative events.proto
@@ -0,0 +1,62 @@
+package hub
+
+import (
+	"context"
+)
+
+const (
+	// EventUserJoined is sent to a room when a user shows up in it
+	EventUserJoined = "user_joined"
+	// EventUserLeft is sent to a room when the last client of a user leaves it
+	EventUserLeft = "user_left"
+	// EventPresenceSnapshot is sent to a client entering a room and lists who is there
+	EventPresenceSnapshot = "presence_snapshot"
+	// EventListMembers is a RPC returning the presence snapshot of the current room
+	EventListMembers = "list_members"
+)
+
+// PresenceEvent is the payload of user_joined and user_left
+type PresenceEvent struct {
+	Room     string `json:"room"`
+	Username string `json:"username"`
+}
+
+// PresenceSnapshotEvent is the payload of presence_snapshot and the result of list_members.
+// A user with many tabs open is only listed once
+type PresenceSnapshotEvent struct {
+	Room    string   `json:"room"`
+	Members []string `json:"members"`
+}
+
+// announce tells the other users in the rooms about users joining and leaving them.
+// Never call it with a lock held, it sends to every member of the rooms
+func (m *Manager) announce(changes []presenceChange) {
+	for _, change := range changes {
+		eventType := EventUserLeft
+		if change.joined {
+			eventType = EventUserJoined
+		}
+		payload := NewPayload(PresenceEvent{Room: change.room, Username: change.username})
+		// The user itself gets a snapshot instead
+		m.broadcastOthers(change.room, change.username, Event{Type: eventType, Payload: payload})
+	}
+}
+
+// presenceSnapshot lists the users in room
+func (m *Manager) presenceSnapshot(room string) PresenceSnapshotEvent {
+	return PresenceSnapshotEvent{Room: room, Members: m.rooms.Usernames(room)}
+}
+
+// sendPresence sends the client who is in the room it just entered
+func (c *Client) sendPresence(room string) {
+	c.Send(Event{Type: EventPresenceSnapshot, Payload: NewPayload(c.manager.presenceSnapshot(room))})
+}
+
+// ListMembersHandler returns the users in the room of the client
+func ListMembersHandler(ctx context.Context, event Event, c *Client) (any, error) {
+	room, ok := c.manager.rooms.RoomOf(c)
+	if !ok {
+		return nil, ErrNotInRoom
+	}
+	return c.manager.presenceSnapshot(room), nil
+}
@@ -0,0 +1,94 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRoomRegistry_PresenceDedupesUsers(t *testing.T) {
+	rr := NewRoomRegistry()
+	tab1 := &Client{identity: Identity{Username: "percy"}}
+	tab2 := &Client{identity: Identity{Username: "percy"}}
+
+	if _, changes := rr.join(tab1, "general"); len(changes) != 1 || !changes[0].joined {
+		t.Errorf("expected the first tab to join percy, got %+v", changes)
+	}
+	if _, changes := rr.join(tab2, "general"); len(changes) != 0 {
+		t.Errorf("expected the second tab to change nothing, got %+v", changes)
+	}
+	if got := fmt.Sprint(rr.Usernames("general")); got != "[percy]" {
+		t.Errorf("expected percy once, got %s", got)
+	}
+	if _, changes := rr.leaveRoom(tab1); len(changes) != 0 {
+		t.Errorf("percy still has a tab open, got %+v", changes)
+	}
+	if _, changes := rr.join(tab2, "games"); len(changes) != 2 || changes[0].joined || changes[0].room != "general" || !changes[1].joined {
+		t.Errorf("expected percy to leave general and join games, got %+v", changes)
+	}
+}
+
+func TestManager_Presence(t *testing.T) {
+	m, srv := newTestServer(t)
+	percy := connect(t, srv, "percy")
+
+	var snapshot PresenceSnapshotEvent
+	readUntil(t, percy, EventPresenceSnapshot, &snapshot)
+	if snapshot.Room != DefaultRoom || fmt.Sprint(snapshot.Members) != "[percy]" {
+		t.Errorf("unexpected snapshot %+v", snapshot)
+	}
+
+	tab1 := NewRPCClient(connect(t, srv, "anna"), nil)
+	var presence PresenceEvent
+	readUntil(t, percy, EventUserJoined, &presence, EventUserLeft)
+	if presence.Username != "anna" || presence.Room != DefaultRoom {
+		t.Errorf("unexpected user_joined %+v", presence)
+	}
+	tab2 := NewRPCClient(connect(t, srv, "anna"), nil)
+	waitForClients(t, m, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := tab2.Call(ctx, EventListMembers, nil, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(snapshot.Members) != "[anna percy]" {
+		t.Errorf("expected anna once, got %v", snapshot.Members)
+	}
+
+	// anna is still in general with her first tab, so percy should hear nothing
+	if err := tab2.Call(ctx, EventChangeRoom, ChangeRoomEvent{Name: "games"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	sendEvent(t, percy, EventSendMessage, SendMessageEvent{Message: "still here?"})
+	if event := readAnyEvent(t, percy); event.Type != EventNewMessage {
+		t.Fatalf("expected no presence for the second tab, got %s: %s", event.Type, event.Payload)
+	}
+
+	if err := tab1.Call(ctx, EventChangeRoom, ChangeRoomEvent{Name: "games"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, percy, EventUserLeft, &presence, EventUserJoined)
+	if presence.Username != "anna" || presence.Room != DefaultRoom {
+		t.Errorf("unexpected user_left %+v", presence)
+	}
+}
+
+func TestManager_PresenceLeftAfterSessionExpires(t *testing.T) {
+	m, srv := newTestServer(t, WithOTPTTL(50*time.Millisecond), WithSessions(50*time.Millisecond, 16))
+	percy := connect(t, srv, "percy")
+	anna := connect(t, srv, "anna")
+	waitForClients(t, m, 2)
+
+	var presence PresenceEvent
+	readUntil(t, percy, EventPresenceSnapshot, &PresenceSnapshotEvent{})
+	readUntil(t, percy, EventUserJoined, &presence, EventUserLeft)
+
+	// anna could still resume, so she is only gone once the session expires
+	anna.Close()
+	readUntil(t, percy, EventUserLeft, &presence, EventUserJoined)
+	if presence.Username != "anna" {
+		t.Errorf("expected anna to leave, got %+v", presence)
+	}
+}
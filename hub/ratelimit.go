@@ -0,0 +1,360 @@
+package hub
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrCodeRateLimited is used when a event is dropped because the user sends too fast
+const ErrCodeRateLimited = "rate_limited"
+
+// rateLimitReason is sent in the close frame of clients disconnected for sending too fast
+const rateLimitReason = "rate limit exceeded"
+
+// ErrRateLimited is sent back for events dropped by the RateLimitDrop action
+var ErrRateLimited = NewHandlerError(ErrCodeRateLimited, "you are sending too fast, slow down")
+
+// RateLimitAction decides what happens when a user sends events faster than its limits
+type RateLimitAction int
+
+const (
+	// RateLimitDrop drops the event and answers it with a rate_limited error
+	RateLimitDrop RateLimitAction = iota
+	// RateLimitMute drops every event of the user for MuteDuration, each answered with a rate_limited error
+	RateLimitMute
+	// RateLimitDisconnect closes the connection that went over the limit with 1008
+	RateLimitDisconnect
+)
+
+// String returns the name of the action as used in logs
+func (a RateLimitAction) String() string {
+	switch a {
+	case RateLimitDrop:
+		return "drop"
+	case RateLimitMute:
+		return "mute"
+	case RateLimitDisconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// Set parses the name of a action as returned by String, it makes RateLimitAction usable as a flag
+func (a *RateLimitAction) Set(value string) error {
+	for _, action := range []RateLimitAction{RateLimitDrop, RateLimitMute, RateLimitDisconnect} {
+		if action.String() == value {
+			*a = action
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown rate limit action %q", value)
+}
+
+// RateLimit is a token bucket, it allows Burst events at once and refills at Rate events per second.
+// A zero Rate means no limit
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// String returns the limit as rate:burst, or 0 for no limit
+func (l RateLimit) String() string {
+	if l.Rate == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(l.Rate, 'f', -1, 64) + ":" + strconv.Itoa(l.Burst)
+}
+
+// Set parses a limit as returned by String, it makes RateLimit usable as a flag
+func (l *RateLimit) Set(value string) error {
+	if value == "" || value == "0" {
+		*l = RateLimit{}
+		return nil
+	}
+	rate, burst, ok := strings.Cut(value, ":")
+	if !ok {
+		return fmt.Errorf("rate limit %q has to be rate:burst", value)
+	}
+	parsedRate, err := strconv.ParseFloat(rate, 64)
+	if err != nil {
+		return fmt.Errorf("bad rate in %q: %w", value, err)
+	}
+	parsedBurst, err := strconv.Atoi(burst)
+	if err != nil {
+		return fmt.Errorf("bad burst in %q: %w", value, err)
+	}
+	*l = RateLimit{Rate: parsedRate, Burst: parsedBurst}
+	return nil
+}
+
+// valid reports if the limit can be used, a limit with a rate has to allow at least one event
+func (l RateLimit) valid() bool {
+	return l.Rate == 0 || (l.Rate > 0 && l.Burst >= 1)
+}
+
+// eventRateLimits is a flag.Value of comma separated type=rate:burst limits
+type eventRateLimits map[string]RateLimit
+
+func (e *eventRateLimits) String() string {
+	if e == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(*e))
+	for _, eventType := range sortedKeys(*e) {
+		parts = append(parts, eventType+"="+(*e)[eventType].String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set replaces the limits, the map is never changed in place as it may be shared with DefaultRateLimitConfig
+func (e *eventRateLimits) Set(value string) error {
+	limits := make(map[string]RateLimit)
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		eventType, raw, ok := strings.Cut(part, "=")
+		if !ok || eventType == "" {
+			return fmt.Errorf("event rate limit %q has to be type=rate:burst", part)
+		}
+		var limit RateLimit
+		if err := limit.Set(raw); err != nil {
+			return err
+		}
+		limits[eventType] = limit
+	}
+	*e = limits
+	return nil
+}
+
+// RateLimitConfig configures the limits of the events sent by each user. The limits are kept per user,
+// so they hold across every connection of the user
+type RateLimitConfig struct {
+	// User limits all the events of a user together
+	User RateLimit
+	// Events limits the events of a user by type, on top of User
+	Events map[string]RateLimit
+	// Action is what happens to a event over the limit
+	Action RateLimitAction
+	// MuteDuration is how long the RateLimitMute action drops the events of the user
+	MuteDuration time.Duration
+}
+
+// DefaultRateLimitConfig returns the limits used by the Manager unless anything else is configured.
+// It is a function so the Events map is never shared between configs
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		User: RateLimit{Rate: 20, Burst: 40},
+		Events: map[string]RateLimit{
+			EventSendMessage: {Rate: 5, Burst: 10},
+			EventSendDirect:  {Rate: 5, Burst: 10},
+		},
+		Action:       RateLimitDrop,
+		MuteDuration: 30 * time.Second,
+	}
+}
+
+// tokenBucket holds the tokens left of a RateLimit
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last refill, a new bucket starts full
+func (b *tokenBucket) refill(limit RateLimit, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = float64(limit.Burst)
+	} else {
+		b.tokens = min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	}
+	b.last = now
+}
+
+// full returns when the bucket has refilled completely, after that it is the same as a new bucket
+func (b *tokenBucket) full(limit RateLimit) time.Time {
+	missing := float64(limit.Burst) - b.tokens
+	return b.last.Add(time.Duration(missing / limit.Rate * float64(time.Second)))
+}
+
+// userRateLimit is the state of the limits of one user
+type userRateLimit struct {
+	total  tokenBucket
+	events map[string]*tokenBucket
+	// mutedUntil is set by the RateLimitMute action
+	mutedUntil time.Time
+	// idleAt is when every bucket is full and the mute is over, the user can be forgotten after that
+	idleAt time.Time
+}
+
+// rateVerdict is what the rateLimiter decided about a event
+type rateVerdict struct {
+	allowed bool
+	// mutedUntil is set while the user is muted
+	mutedUntil time.Time
+	// muted is true if this event got the user muted
+	muted bool
+}
+
+// rateLimiter enforces a RateLimitConfig, it is shared by every client of the manager
+type rateLimiter struct {
+	config RateLimitConfig
+
+	mu    sync.Mutex
+	users map[string]*userRateLimit
+	// lastSweep is when idle users were last forgotten
+	lastSweep time.Time
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		config: config,
+		users:  make(map[string]*userRateLimit),
+	}
+}
+
+// allowFrame takes a token from the User bucket for a frame that has not been decoded yet, so frames
+// that are not even valid events are limited too. A muted user is dropped here for everything
+func (rl *rateLimiter) allowFrame(username string, now time.Time) rateVerdict {
+	limit := rl.config.User
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.sweep(now)
+	user, ok := rl.users[username]
+	// A muted user is muted for every event, even the ones without a limit
+	if ok && now.Before(user.mutedUntil) {
+		return rateVerdict{mutedUntil: user.mutedUntil}
+	}
+	if limit.Rate == 0 {
+		return rateVerdict{allowed: true}
+	}
+	if !ok {
+		user = rl.newUser(username)
+	}
+	if !user.take(&user.total, limit, now) {
+		return rl.overLimit(user, now)
+	}
+	return rateVerdict{allowed: true}
+}
+
+// allowEvent takes a token from the bucket of eventType once the frame is decoded. A event dropped
+// by its type gets its User token back, so it does not count towards User
+func (rl *rateLimiter) allowEvent(username, eventType string, now time.Time) rateVerdict {
+	limit, ok := rl.config.Events[eventType]
+	if !ok || limit.Rate == 0 {
+		return rateVerdict{allowed: true}
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	user, ok := rl.users[username]
+	if !ok {
+		user = rl.newUser(username)
+	}
+	bucket, ok := user.events[eventType]
+	if !ok {
+		bucket = &tokenBucket{}
+		user.events[eventType] = bucket
+	}
+	if user.take(bucket, limit, now) {
+		return rateVerdict{allowed: true}
+	}
+	if userLimit := rl.config.User; userLimit.Rate > 0 {
+		user.total.tokens = min(float64(userLimit.Burst), user.total.tokens+1)
+	}
+	return rl.overLimit(user, now)
+}
+
+// newUser starts tracking the limits of username
+func (rl *rateLimiter) newUser(username string) *userRateLimit {
+	user := &userRateLimit{events: make(map[string]*tokenBucket)}
+	rl.users[username] = user
+	return user
+}
+
+// take refills bucket and takes a token from it if it has one
+func (user *userRateLimit) take(bucket *tokenBucket, limit RateLimit, now time.Time) bool {
+	bucket.refill(limit, now)
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	if full := bucket.full(limit); full.After(user.idleAt) {
+		user.idleAt = full
+	}
+	return allowed
+}
+
+// overLimit is the verdict for a user that ran out of tokens, it mutes the user with the RateLimitMute action
+func (rl *rateLimiter) overLimit(user *userRateLimit, now time.Time) rateVerdict {
+	if rl.config.Action == RateLimitMute {
+		user.mutedUntil = now.Add(rl.config.MuteDuration)
+		if user.mutedUntil.After(user.idleAt) {
+			user.idleAt = user.mutedUntil
+		}
+		return rateVerdict{mutedUntil: user.mutedUntil, muted: true}
+	}
+	return rateVerdict{}
+}
+
+// sweep forgets the users that has been idle long enough for all their buckets to be full again,
+// keeping them would change nothing. It runs at most once a minute
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < time.Minute {
+		return
+	}
+	rl.lastSweep = now
+	for username, user := range rl.users {
+		if !now.Before(user.idleAt) {
+			delete(rl.users, username)
+		}
+	}
+}
+
+// allowFrame charges a received frame to the rate limit of the user before it is decoded.
+// False means the frame is dropped
+func (c *Client) allowFrame() bool {
+	verdict := c.manager.limiter.allowFrame(c.identity.Username, time.Now())
+	// The type is not known before decoding
+	return c.rateLimited(Event{}, unknownEventType, verdict)
+}
+
+// allowEvent checks a decoded event against the rate limit of its type before it is routed.
+// False means the event is dropped
+func (c *Client) allowEvent(request Event) bool {
+	verdict := c.manager.limiter.allowEvent(c.identity.Username, request.Type, time.Now())
+	return c.rateLimited(request, c.manager.eventLabel(request.Type), verdict)
+}
+
+// rateLimited handles what is over the limit with the configured action, it returns verdict.allowed
+func (c *Client) rateLimited(request Event, label string, verdict rateVerdict) bool {
+	if verdict.allowed {
+		return true
+	}
+	c.manager.metrics.rateLimited.inc(label)
+
+	switch {
+	case c.manager.config.RateLimit.Action == RateLimitDisconnect:
+		c.log(slog.LevelWarn, "disconnecting, rate limit exceeded", "event", label)
+		c.close(websocket.ClosePolicyViolation, rateLimitReason)
+	case !verdict.mutedUntil.IsZero():
+		if verdict.muted {
+			c.log(slog.LevelWarn, "muted, rate limit exceeded", "event", label, "until", verdict.mutedUntil)
+		}
+		wait := time.Until(verdict.mutedUntil).Round(time.Second)
+		c.sendError(request, NewHandlerError(ErrCodeRateLimited, fmt.Sprintf("you are sending too fast, muted for %s", wait)))
+	default:
+		c.log(slog.LevelDebug, "dropped event, rate limit exceeded", "event", label)
+		c.sendError(request, ErrRateLimited)
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package hub
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRateLimit_Set(t *testing.T) {
+	var limit RateLimit
+	if err := limit.Set("0.5:3"); err != nil || limit != (RateLimit{Rate: 0.5, Burst: 3}) || limit.String() != "0.5:3" {
+		t.Errorf("expected 0.5:3 to parse, got %+v %v", limit, err)
+	}
+	if err := limit.Set("5"); err == nil {
+		t.Error("expected a limit without a burst to fail")
+	}
+
+	var events eventRateLimits
+	if err := events.Set("typing_start=2:4, send_message=0"); err != nil {
+		t.Fatal(err)
+	}
+	if got := events.String(); got != "send_message=0,typing_start=2:4" {
+		t.Errorf("expected the limits sorted by type, got %q", got)
+	}
+}
+
+// allow charges a event to rl the way readMessages does, as a frame and then by its type
+func allow(rl *rateLimiter, username, eventType string, now time.Time) rateVerdict {
+	if verdict := rl.allowFrame(username, now); !verdict.allowed {
+		return verdict
+	}
+	return rl.allowEvent(username, eventType, now)
+}
+
+func TestRateLimiter_Allow(t *testing.T) {
+	now := time.Now()
+	rl := newRateLimiter(RateLimitConfig{
+		User:   RateLimit{Rate: 1, Burst: 3},
+		Events: map[string]RateLimit{EventSendMessage: {Rate: 1, Burst: 1}},
+	})
+
+	if !allow(rl, "percy", EventSendMessage, now).allowed {
+		t.Fatal("expected the first message to be allowed")
+	}
+	if allow(rl, "percy", EventSendMessage, now).allowed {
+		t.Fatal("expected the second message to be over the limit of the type")
+	}
+	// The dropped message did not use a token of the user, so two other events still fit
+	for i := 0; i < 2; i++ {
+		if !allow(rl, "percy", EventChangeRoom, now).allowed {
+			t.Fatalf("expected event %d to be within the limit of the user", i)
+		}
+	}
+	if allow(rl, "percy", EventChangeRoom, now).allowed {
+		t.Fatal("expected the limit of the user to be used up")
+	}
+	if !allow(rl, "anna", EventSendMessage, now).allowed {
+		t.Fatal("expected every user to have limits of their own")
+	}
+	if !allow(rl, "percy", EventSendMessage, now.Add(time.Second)).allowed {
+		t.Fatal("expected the buckets to refill")
+	}
+
+	// Users that are back to full buckets are forgotten
+	allow(rl, "percy", EventChangeRoom, now.Add(time.Hour))
+	if _, ok := rl.users["anna"]; ok {
+		t.Error("expected the idle user to be swept")
+	}
+}
+
+func TestRateLimiter_Mute(t *testing.T) {
+	now := time.Now()
+	rl := newRateLimiter(RateLimitConfig{
+		Events:       map[string]RateLimit{EventSendMessage: {Rate: 1, Burst: 1}},
+		Action:       RateLimitMute,
+		MuteDuration: time.Minute,
+	})
+
+	allow(rl, "percy", EventSendMessage, now)
+	verdict := allow(rl, "percy", EventSendMessage, now)
+	if verdict.allowed || !verdict.muted || !verdict.mutedUntil.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected going over the limit to mute, got %+v", verdict)
+	}
+	// Muted for everything, even events without a limit
+	if verdict := allow(rl, "percy", EventChangeRoom, now.Add(time.Second)); verdict.allowed || verdict.muted {
+		t.Errorf("expected the event to be dropped while muted, got %+v", verdict)
+	}
+	if !allow(rl, "percy", EventSendMessage, now.Add(time.Minute)).allowed {
+		t.Error("expected the mute to be over")
+	}
+}
+
+func TestManager_RateLimitAcrossTabs(t *testing.T) {
+	m, srv := newTestServer(t, WithRateLimit(RateLimitConfig{
+		Events: map[string]RateLimit{EventSendMessage: {Rate: 0.001, Burst: 2}},
+	}))
+	tab1 := connect(t, srv, "percy")
+	tab2 := connect(t, srv, "percy")
+	waitForClients(t, m, 2)
+
+	call(t, tab1, "1", EventSendMessage, SendMessageEvent{Message: "one"})
+	readUntil(t, tab1, EventAck, nil, EventError)
+	call(t, tab2, "2", EventSendMessage, SendMessageEvent{Message: "two"})
+	readUntil(t, tab2, EventAck, nil, EventError)
+
+	// The limit belongs to the user, not to the connection
+	call(t, tab1, "3", EventSendMessage, SendMessageEvent{Message: "three"})
+	var errEvent ErrorEvent
+	readUntil(t, tab1, EventError, &errEvent, EventAck)
+	if errEvent.ID != "3" || errEvent.Code != ErrCodeRateLimited {
+		t.Errorf("expected 3 to be rate limited, got %+v", errEvent)
+	}
+	if count := m.metrics.rateLimited.snapshot()[EventSendMessage]; count != 1 {
+		t.Errorf("expected 1 rate limited message to be counted, got %d", count)
+	}
+}
+
+func TestManager_RateLimitDisconnect(t *testing.T) {
+	m, srv := newTestServer(t, WithRateLimit(RateLimitConfig{
+		User:   RateLimit{Rate: 0.001, Burst: 1},
+		Action: RateLimitDisconnect,
+	}))
+	conn := connect(t, srv, "percy")
+	waitForClients(t, m, 1)
+
+	sendEvent(t, conn, EventSendMessage, SendMessageEvent{Message: "one"})
+	sendEvent(t, conn, EventSendMessage, SendMessageEvent{Message: "two"})
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+			t.Fatalf("expected to be closed with 1008, got %v", err)
+		}
+		break
+	}
+	waitForClients(t, m, 0)
+}
+
+func TestManager_RateLimitInvalidFrames(t *testing.T) {
+	m, srv := newTestServer(t, WithRateLimit(RateLimitConfig{
+		User: RateLimit{Rate: 0.001, Burst: 2},
+	}))
+	conn := connect(t, srv, "percy")
+	waitForClients(t, m, 1)
+
+	// Frames that are not events use up the limit of the user as well
+	for i := 0; i < 3; i++ {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("garbage")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{ErrCodeBadRequest, ErrCodeBadRequest, ErrCodeRateLimited} {
+		var errEvent ErrorEvent
+		readUntil(t, conn, EventError, &errEvent)
+		if errEvent.Code != want {
+			t.Errorf("expected %s, got %+v", want, errEvent)
+		}
+	}
+	if count := m.metrics.eventsReceived.snapshot()[invalidEventType]; count != 2 {
+		t.Errorf("expected only the 2 frames within the limit to be decoded, got %d", count)
+	}
+}
@@ -0,0 +1,105 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// EventAck is sent back when a event carrying a id has been handled
+	EventAck = "ack"
+	// EventError is sent back when a event could not be handled
+	EventError = "error"
+)
+
+// Error codes sent in the error event, the frontend can switch on these
+const (
+	// ErrCodeBadRequest is used when the event itself could not be parsed
+	ErrCodeBadRequest = "bad_request"
+	// ErrCodeUnsupportedEvent is used when there is no handler for the event type
+	ErrCodeUnsupportedEvent = "unsupported_event"
+	// ErrCodeBadPayload is used when the payload does not match the event type
+	ErrCodeBadPayload = "bad_payload"
+	// ErrCodeInvalid is used when the payload is well formed but not allowed
+	ErrCodeInvalid = "invalid"
+	// ErrCodeForbidden is used when the user is not allowed to do what it tried
+	ErrCodeForbidden = "forbidden"
+	// ErrCodeTimeout is used when a RPC does not finish in time
+	ErrCodeTimeout = "timeout"
+	// ErrCodeInternal is used for every error that is not a HandlerError
+	ErrCodeInternal = "internal_error"
+)
+
+// HandlerError is a error that is reported back to the client with a machine readable code.
+// Handlers that return any other error will be reported as ErrCodeInternal
+type HandlerError struct {
+	// Code is the machine readable code, one of the ErrCode constants or a handler specific one
+	Code string
+	// Message is a human readable description that is safe to show to the user
+	Message string
+	// Err is the underlying error, if any, it is only logged
+	Err error
+}
+
+// NewHandlerError creates a HandlerError with code and message
+func NewHandlerError(code, message string) *HandlerError {
+	return &HandlerError{Code: code, Message: message}
+}
+
+// Error implements error
+func (e *HandlerError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the underlying error
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// errBadPayload is returned by handlers when the payload can not be unmarshalled
+func errBadPayload(err error) *HandlerError {
+	return &HandlerError{Code: ErrCodeBadPayload, Message: fmt.Sprintf("bad payload in request: %v", err), Err: err}
+}
+
+// AckEvent is the payload of the ack event
+type AckEvent struct {
+	ID string `json:"id"`
+}
+
+// ErrorEvent is the payload of the error event
+type ErrorEvent struct {
+	// ID is the id of the event that failed, empty if the event had none
+	ID      string `json:"id,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// newErrorEvent converts any error into the payload sent to the client, only the
+// message of a HandlerError is exposed, other errors could leak internal details
+func newErrorEvent(id string, err error) ErrorEvent {
+	var eventErr *HandlerError
+	if errors.As(err, &eventErr) {
+		return ErrorEvent{ID: id, Code: eventErr.Code, Message: eventErr.Message}
+	}
+	return ErrorEvent{ID: id, Code: ErrCodeInternal, Message: "internal error"}
+}
+
+// sendAck tells the client that request was handled
+func (c *Client) sendAck(request Event) {
+	c.sendReply(EventAck, request, AckEvent{ID: request.ID})
+}
+
+// sendError tells the client that request failed because of err
+func (c *Client) sendError(request Event, err error) {
+	c.sendReply(EventError, request, newErrorEvent(request.ID, err))
+}
+
+// sendReply queues a event of eventType replying to request for the client.
+// It carries the trace context of the request, so the reply can be linked to it
+func (c *Client) sendReply(eventType string, request Event, payload any) {
+	reply := Event{Type: eventType, ReplyTo: request.ID, Payload: NewPayload(payload)}
+	c.Send(reply.WithContext(request.Context()))
+}
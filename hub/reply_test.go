@@ -0,0 +1,82 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNewErrorEvent(t *testing.T) {
+	testCases := []struct {
+		name    string
+		err     error
+		code    string
+		message string
+	}{
+		{name: "sentinel", err: ErrEventNotSupported, code: ErrCodeUnsupportedEvent, message: "this event type is not supported"},
+		{name: "wrapped", err: fmt.Errorf("room handler: %w", ErrEmptyRoomName), code: ErrCodeInvalid, message: "room name can not be empty"},
+		{name: "bad payload", err: errBadPayload(errors.New("unexpected end")), code: ErrCodeBadPayload, message: "bad payload in request: unexpected end"},
+		{name: "untyped", err: errors.New("database password is hunter2"), code: ErrCodeInternal, message: "internal error"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := newErrorEvent("42", tc.err)
+			if got.ID != "42" || got.Code != tc.code || got.Message != tc.message {
+				t.Errorf("unexpected error event %+v", got)
+			}
+		})
+	}
+}
+
+func TestClient_RepliesToEvents(t *testing.T) {
+	m, srv := newTestServer(t)
+	conn := connect(t, srv, "percy")
+	waitForClients(t, m, 1)
+
+	// A handled event with a id is acknowledged
+	conn.WriteJSON(Event{Type: EventChangeRoom, ID: "1", Payload: NewPayload(json.RawMessage(`{"name":"games"}`))})
+	var history HistoryEvent
+	readUntil(t, conn, EventHistory, &history, EventError)
+	var ack AckEvent
+	readUntil(t, conn, EventAck, &ack, EventError)
+	if ack.ID != "1" {
+		t.Errorf("expected ack for 1, got %q", ack.ID)
+	}
+
+	testCases := []struct {
+		name    string
+		message string
+		id      string
+		code    string
+	}{
+		{name: "unsupported", message: `{"type":"dance","id":"2"}`, id: "2", code: ErrCodeUnsupportedEvent},
+		{name: "bad payload", message: `{"type":"change_room","id":"3","payload":"games"}`, id: "3", code: ErrCodeBadPayload},
+		{name: "handler error", message: `{"type":"change_room","id":"4","payload":{"name":""}}`, id: "4", code: ErrCodeInvalid},
+		{name: "spoofed sender", message: `{"type":"send_message","id":"5","payload":{"message":"hi","from":"anna"}}`, id: "5", code: ErrCodeForbidden},
+		{name: "not json", message: `hello?`, code: ErrCodeBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(tc.message)); err != nil {
+				t.Fatal(err)
+			}
+			var errEvent ErrorEvent
+			readUntil(t, conn, EventError, &errEvent)
+			if errEvent.ID != tc.id || errEvent.Code != tc.code || errEvent.Message == "" {
+				t.Errorf("unexpected error event %+v", errEvent)
+			}
+		})
+	}
+
+	// The connection should survive all the errors above
+	conn.WriteJSON(Event{Type: EventChangeRoom, ID: "6", Payload: NewPayload(json.RawMessage(`{"name":"general"}`))})
+	readUntil(t, conn, EventHistory, &history, EventError)
+	readUntil(t, conn, EventAck, &ack, EventError)
+	if ack.ID != "6" {
+		t.Errorf("expected ack for 6, got %q", ack.ID)
+	}
+}
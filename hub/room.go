@@ -0,0 +1,264 @@
+package hub
+
+import (
+	"sort"
+	"sync"
+)
+
+// DefaultRoom is the room all clients are placed in when they connect
+const DefaultRoom = "general"
+
+// Room is a chat room and the clients that are currently in it
+type Room struct {
+	name string
+	// members are the clients in the room
+	members ClientList
+	// users counts the members of each user, a user with many tabs open is only present once
+	users map[string]int
+}
+
+// add puts c in the room and returns true if its user was not present before
+func (r *Room) add(c *Client) bool {
+	r.members[c] = true
+	r.users[c.identity.Username]++
+	return r.users[c.identity.Username] == 1
+}
+
+// remove takes c out of the room and returns true if it was the last client of its user
+func (r *Room) remove(c *Client) bool {
+	if !r.members[c] {
+		return false
+	}
+	delete(r.members, c)
+	r.users[c.identity.Username]--
+	if r.users[c.identity.Username] > 0 {
+		return false
+	}
+	delete(r.users, c.identity.Username)
+	return true
+}
+
+// Name is the name of the room
+func (r *Room) Name() string {
+	return r.name
+}
+
+// RoomRegistry keeps track of all rooms and which room each client is in.
+// Rooms are created when the first client joins and removed once the last one leaves
+type RoomRegistry struct {
+	sync.RWMutex
+	// rooms are all rooms with at least one member, by name
+	rooms map[string]*Room
+	// clients is the room each client is in, used to find the room to leave
+	clients map[*Client]*Room
+}
+
+// NewRoomRegistry creates a empty RoomRegistry
+func NewRoomRegistry() *RoomRegistry {
+	return &RoomRegistry{
+		rooms:   make(map[string]*Room),
+		clients: make(map[*Client]*Room),
+	}
+}
+
+// presenceChange is a user showing up in a room, or leaving it with its last client
+type presenceChange struct {
+	room     string
+	username string
+	joined   bool
+}
+
+// Join moves the client into the room called name, leaving any room it was in before.
+// It returns the name of the previous room, or a empty string if the client was not in one
+func (rr *RoomRegistry) Join(c *Client, name string) string {
+	previous, _ := rr.join(c, name)
+	return previous
+}
+
+// join is Join that also reports how the presence of the user changed
+func (rr *RoomRegistry) join(c *Client, name string) (string, []presenceChange) {
+	rr.Lock()
+	defer rr.Unlock()
+
+	if current, ok := rr.clients[c]; ok && current.name == name {
+		// Already there, nobody has to hear about it
+		return name, nil
+	}
+
+	previous, changes := rr.leave(c)
+
+	room, ok := rr.rooms[name]
+	if !ok {
+		room = &Room{name: name, members: make(ClientList), users: make(map[string]int)}
+		rr.rooms[name] = room
+	}
+	if room.add(c) {
+		changes = append(changes, presenceChange{room: name, username: c.identity.Username, joined: true})
+	}
+	rr.clients[c] = room
+	return previous, changes
+}
+
+// Leave removes the client from the room it is in and returns the name of that room
+func (rr *RoomRegistry) Leave(c *Client) string {
+	left, _ := rr.leaveRoom(c)
+	return left
+}
+
+// leaveRoom is Leave that also reports if the user left the room
+func (rr *RoomRegistry) leaveRoom(c *Client) (string, []presenceChange) {
+	rr.Lock()
+	defer rr.Unlock()
+
+	return rr.leave(c)
+}
+
+// leave has to be called with the lock held
+func (rr *RoomRegistry) leave(c *Client) (string, []presenceChange) {
+	room, ok := rr.clients[c]
+	if !ok {
+		return "", nil
+	}
+	delete(rr.clients, c)
+	var changes []presenceChange
+	if room.remove(c) {
+		changes = append(changes, presenceChange{room: room.name, username: c.identity.Username})
+	}
+	// Garbage collect the room once it is empty
+	if len(room.members) == 0 {
+		delete(rr.rooms, room.name)
+	}
+	return room.name, changes
+}
+
+// Replace puts c in the room of previous, which leaves it. It returns false if previous is not in a room
+func (rr *RoomRegistry) Replace(previous, c *Client) bool {
+	rr.Lock()
+	defer rr.Unlock()
+
+	room, ok := rr.clients[previous]
+	if !ok {
+		return false
+	}
+	rr.leave(c)
+	// Add before removing, so the same user never looks like it left
+	room.add(c)
+	room.remove(previous)
+	delete(rr.clients, previous)
+	rr.clients[c] = room
+	return true
+}
+
+// RoomOf returns the name of the room the client is in
+func (rr *RoomRegistry) RoomOf(c *Client) (string, bool) {
+	rr.RLock()
+	defer rr.RUnlock()
+
+	room, ok := rr.clients[c]
+	if !ok {
+		return "", false
+	}
+	return room.name, true
+}
+
+// Members returns a copy of the clients in the room, so it is safe to use after the lock is released
+func (rr *RoomRegistry) Members(name string) []*Client {
+	rr.RLock()
+	defer rr.RUnlock()
+
+	room, ok := rr.rooms[name]
+	if !ok {
+		return nil
+	}
+	members := make([]*Client, 0, len(room.members))
+	for client := range room.members {
+		members = append(members, client)
+	}
+	return members
+}
+
+// Usernames returns the sorted users present in the room, each user once no matter how many clients it has
+func (rr *RoomRegistry) Usernames(name string) []string {
+	rr.RLock()
+	defer rr.RUnlock()
+
+	usernames := []string{}
+	if room, ok := rr.rooms[name]; ok {
+		for username := range room.users {
+			usernames = append(usernames, username)
+		}
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+// Rooms returns the sorted names of all rooms that has members
+func (rr *RoomRegistry) Rooms() []string {
+	rr.RLock()
+	defer rr.RUnlock()
+
+	names := make([]string, 0, len(rr.rooms))
+	for name := range rr.rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RoomStore keeps the rooms and the room each user was last in, so they survive a restart.
+// It is only told about explicit room changes, not about clients placed in DefaultRoom
+type RoomStore interface {
+	// SaveMembership records that username is in room, creating the room if it is new
+	SaveMembership(username, room string) error
+	// Membership returns the room username was last in, ok is false if it is not known
+	Membership(username string) (room string, ok bool, err error)
+	// Rooms returns the names of every stored room, sorted
+	Rooms() ([]string, error)
+}
+
+// MemoryRoomStore is a RoomStore that forgets everything on restart
+type MemoryRoomStore struct {
+	sync.RWMutex
+	rooms       map[string]bool
+	memberships map[string]string
+}
+
+// NewMemoryRoomStore creates a empty MemoryRoomStore
+func NewMemoryRoomStore() *MemoryRoomStore {
+	return &MemoryRoomStore{
+		rooms:       make(map[string]bool),
+		memberships: make(map[string]string),
+	}
+}
+
+// SaveMembership records the room of username
+func (ms *MemoryRoomStore) SaveMembership(username, room string) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	ms.rooms[room] = true
+	ms.memberships[username] = room
+	return nil
+}
+
+// Membership returns the room username was last in
+func (ms *MemoryRoomStore) Membership(username string) (string, bool, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	room, ok := ms.memberships[username]
+	return room, ok, nil
+}
+
+// Rooms returns all rooms anyone has joined
+func (ms *MemoryRoomStore) Rooms() ([]string, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	names := make([]string, 0, len(ms.rooms))
+	for name := range ms.rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
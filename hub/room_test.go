@@ -0,0 +1,130 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRoomRegistry_JoinAndLeave(t *testing.T) {
+	rr := NewRoomRegistry()
+	percy, anna := &Client{}, &Client{}
+
+	if previous := rr.Join(percy, "general"); previous != "" {
+		t.Errorf("expected no previous room, got %q", previous)
+	}
+	rr.Join(anna, "general")
+	if previous := rr.Join(percy, "games"); previous != "general" {
+		t.Errorf("expected previous room general, got %q", previous)
+	}
+
+	if members := rr.Members("general"); len(members) != 1 || members[0] != anna {
+		t.Errorf("expected only anna in general, got %v", members)
+	}
+	if room, _ := rr.RoomOf(percy); room != "games" {
+		t.Errorf("expected percy in games, got %q", room)
+	}
+	if rooms := rr.Rooms(); !reflect.DeepEqual(rooms, []string{"games", "general"}) {
+		t.Errorf("unexpected rooms %v", rooms)
+	}
+
+	// Empty rooms should be garbage collected
+	rr.Leave(percy)
+	if rooms := rr.Rooms(); !reflect.DeepEqual(rooms, []string{"general"}) {
+		t.Errorf("expected games to be removed, got %v", rooms)
+	}
+	if _, ok := rr.RoomOf(percy); ok {
+		t.Error("percy should not be in a room")
+	}
+	if left := rr.Leave(percy); left != "" {
+		t.Errorf("leaving twice should be a no-op, got %q", left)
+	}
+}
+
+// TestRoomRegistry_Concurrent is meant to be run with -race
+func TestRoomRegistry_Concurrent(t *testing.T) {
+	rr := NewRoomRegistry()
+	rooms := []string{"a", "b", "c"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := &Client{}
+			for j := 0; j < 100; j++ {
+				rr.Join(c, rooms[j%len(rooms)])
+				rr.Members(rooms[(j+1)%len(rooms)])
+			}
+			rr.Leave(c)
+		}()
+	}
+	wg.Wait()
+
+	if rooms := rr.Rooms(); len(rooms) != 0 {
+		t.Errorf("expected all rooms to be removed, got %v", rooms)
+	}
+}
+
+func TestManager_BroadcastOnlyReachesRoom(t *testing.T) {
+	m, srv := newTestServer(t)
+
+	percy := connect(t, srv, "percy")
+	anna := connect(t, srv, "anna")
+	waitForClients(t, m, 2)
+
+	sendEvent(t, anna, EventChangeRoom, ChangeRoomEvent{Name: "games"})
+	// Wait for anna to actually move before talking
+	waitFor(t, "anna to change room", func() bool { return len(m.rooms.Members("games")) == 1 })
+	if event := readEvent(t, anna); event.Type != EventHistory {
+		t.Fatalf("expected the history of games, got %s", event.Type)
+	}
+
+	sendEvent(t, percy, EventSendMessage, SendMessageEvent{Message: "general only"})
+	m.Broadcast("games", Event{Type: EventNewMessage, Payload: NewPayload(json.RawMessage(`{"message":"games only"}`))})
+
+	var msg NewMessageEvent
+	if err := readEvent(t, anna).Payload.Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Message != "games only" {
+		t.Errorf("anna should only see messages in games, got %q", msg.Message)
+	}
+	if err := readEvent(t, percy).Payload.Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Message != "general only" {
+		t.Errorf("percy should only see messages in general, got %q", msg.Message)
+	}
+}
+
+func TestManager_ReturnsToLastRoom(t *testing.T) {
+	m, srv := newTestServer(t)
+	rc := NewRPCClient(connect(t, srv, "percy"), nil)
+	waitForClients(t, m, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := rc.Call(ctx, EventChangeRoom, ChangeRoomEvent{Name: "games"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	waitForClients(t, m, 0)
+
+	// The room is remembered, even though nobody is in it
+	rc = NewRPCClient(connect(t, srv, "percy"), nil)
+	waitForClients(t, m, 1)
+	var result ListRoomsResult
+	if err := rc.Call(ctx, EventListRooms, nil, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rooms) != 1 || result.Rooms[0] != "games" {
+		t.Errorf("expected percy to be back in games, got %v", result.Rooms)
+	}
+	if room, ok, _ := m.config.RoomStore.Membership("percy"); !ok || room != "games" {
+		t.Errorf("expected the membership of percy to be games, got %q", room)
+	}
+}
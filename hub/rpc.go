@@ -0,0 +1,132 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// EventReply carries the result of a RPC, its reply_to is the id of the call
+	EventReply = "reply"
+	// EventListRooms is a RPC returning all rooms with members
+	EventListRooms = "list_rooms"
+)
+
+var (
+	// ErrMissingID is returned when a RPC is called without a id to reply to
+	ErrMissingID = NewHandlerError(ErrCodeInvalid, "rpc calls requires a id")
+	// ErrRPCTimeout is returned when a RPC handler does not finish in time
+	ErrRPCTimeout = NewHandlerError(ErrCodeTimeout, "rpc call timed out")
+)
+
+// DefaultRPCTimeout is how long a RPC handler may run unless anything else is configured
+const DefaultRPCTimeout = 10 * time.Second
+
+// RPCHandler handles a request and returns the result that is sent back to the caller.
+// The context is cancelled when the call times out or the client disconnects
+type RPCHandler func(ctx context.Context, event Event, c *Client) (any, error)
+
+// RegisterRPC adds a RPC handler for eventType. Calls has to carry a id, the result is
+// sent back in a reply event with reply_to set to that id, failures as a error event
+func (m *Manager) RegisterRPC(eventType string, handler RPCHandler) {
+	m.handlerMu.Lock()
+	defer m.handlerMu.Unlock()
+
+	m.handlers[eventType] = m.rpcEventHandler(handler)
+	m.rpcs[eventType] = true
+	m.rebuildChain(eventType)
+}
+
+// isRPC reports if eventType is answered by a RPC reply instead of a ack
+func (m *Manager) isRPC(eventType string) bool {
+	m.handlerMu.RLock()
+	defer m.handlerMu.RUnlock()
+	return m.rpcs[eventType]
+}
+
+// rpcEventHandler adapts a RPCHandler into a EventHandler. The handler is run in its
+// own goroutine so a slow call does not stop the client from reading
+func (m *Manager) rpcEventHandler(handler RPCHandler) EventHandler {
+	return func(event Event, c *Client) error {
+		if event.ID == "" {
+			return ErrMissingID
+		}
+
+		// The call outlives the dispatch, so it gets a span of its own that the reply is linked to
+		spanCtx, span := m.tracer.Start(event.Context(), "rpc "+event.Type)
+		event.ctx = spanCtx
+		// The handler is cancelled when the client goes away, but still adds to the trace
+		ctx, cancel := context.WithTimeout(trace.ContextWithSpan(c.ctx, span), m.config.RPCTimeout)
+		go func() {
+			defer span.End()
+			defer cancel()
+
+			started := time.Now()
+			result, err := callRPC(ctx, handler, event, c)
+			m.observeHandler(event.Type, started)
+			// Nobody is listening for the reply anymore
+			if c.ctx.Err() != nil {
+				return
+			}
+			if err == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				err = ErrRPCTimeout
+			}
+			if err != nil {
+				c.log(slog.LevelWarn, "rpc failed", "event", event.Type, "err", err)
+				spanError(span, err)
+				c.sendError(event, err)
+				return
+			}
+			c.sendResult(event, result)
+		}()
+		return nil
+	}
+}
+
+// callRPC runs the handler, since it runs in its own goroutine a panic is recovered
+// here as the RecoveryMiddleware only sees the handler start
+func callRPC(ctx context.Context, handler RPCHandler, event Event, c *Client) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.log(slog.LevelError, "panic in rpc", "event", event.Type, "panic", r, "stack", string(debug.Stack()))
+			err = &HandlerError{Code: ErrCodeInternal, Message: "internal error", Err: fmt.Errorf("panic: %v", r)}
+		}
+	}()
+	return handler(ctx, event, c)
+}
+
+// sendResult queues a reply event to the call holding result
+func (c *Client) sendResult(request Event, result any) {
+	c.sendReply(EventReply, request, result)
+}
+
+// ListRoomsResult is the result of list_rooms
+type ListRoomsResult struct {
+	Rooms []string `json:"rooms"`
+}
+
+// ListRoomsHandler returns all rooms that currently has members, and the rooms in the RoomStore
+func ListRoomsHandler(ctx context.Context, event Event, c *Client) (any, error) {
+	stored, err := c.manager.config.RoomStore.Rooms()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	rooms := []string{}
+	for _, room := range append(c.manager.rooms.Rooms(), stored...) {
+		if !seen[room] {
+			seen[room] = true
+			rooms = append(rooms, room)
+		}
+	}
+	sort.Strings(rooms)
+	return ListRoomsResult{Rooms: rooms}, nil
+}
@@ -0,0 +1,112 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRPC_ListRooms(t *testing.T) {
+	m, srv := newTestServer(t)
+	rc := NewRPCClient(connect(t, srv, "percy"), nil)
+	waitForClients(t, m, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// Plain events with a id are answered with a ack, so they can be awaited too
+	if err := rc.Call(ctx, EventChangeRoom, ChangeRoomEvent{Name: "games"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var result ListRoomsResult
+	if err := rc.Call(ctx, EventListRooms, nil, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rooms) != 1 || result.Rooms[0] != "games" {
+		t.Errorf("expected only games, got %v", result.Rooms)
+	}
+}
+
+func TestRPC_Errors(t *testing.T) {
+	m, srv := newTestServer(t, WithRPCTimeout(50*time.Millisecond))
+	m.RegisterRPC("fail", func(ctx context.Context, event Event, c *Client) (any, error) {
+		return nil, NewHandlerError("no_luck", "this always fails")
+	})
+	m.RegisterRPC("slow", func(ctx context.Context, event Event, c *Client) (any, error) {
+		<-ctx.Done()
+		return nil, nil
+	})
+
+	rc := NewRPCClient(connect(t, srv, "percy"), nil)
+	waitForClients(t, m, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	testCases := []struct {
+		name      string
+		eventType string
+		code      string
+	}{
+		{name: "handler error", eventType: "fail", code: "no_luck"},
+		{name: "timeout", eventType: "slow", code: ErrCodeTimeout},
+		{name: "unsupported", eventType: "missing", code: ErrCodeUnsupportedEvent},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := rc.Call(ctx, tc.eventType, nil, nil)
+			var handlerErr *HandlerError
+			if !errors.As(err, &handlerErr) {
+				t.Fatalf("expected a HandlerError, got %v", err)
+			}
+			if handlerErr.Code != tc.code {
+				t.Errorf("expected code %s, got %s", tc.code, handlerErr.Code)
+			}
+		})
+	}
+}
+
+func TestRPC_MissingID(t *testing.T) {
+	m, srv := newTestServer(t)
+	conn := connect(t, srv, "percy")
+	waitForClients(t, m, 1)
+
+	sendEvent(t, conn, EventListRooms, nil)
+
+	var errEvent ErrorEvent
+	readUntil(t, conn, EventError, &errEvent)
+	if errEvent.Code != ErrCodeInvalid {
+		t.Errorf("expected %s, got %+v", ErrCodeInvalid, errEvent)
+	}
+}
+
+func TestRPC_CancelledOnDisconnect(t *testing.T) {
+	m, srv := newTestServer(t)
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	m.RegisterRPC("wait", func(ctx context.Context, event Event, c *Client) (any, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	})
+
+	rc := NewRPCClient(connect(t, srv, "percy"), nil)
+	waitForClients(t, m, 1)
+
+	go rc.Call(context.Background(), "wait", nil, nil)
+	<-started
+	rc.Close()
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the handler context was not cancelled when the client left")
+	}
+	<-rc.Done()
+	if err := rc.Call(context.Background(), EventListRooms, nil, nil); !errors.Is(err, ErrRPCClientClosed) {
+		t.Errorf("expected calls on a closed client to fail, got %v", err)
+	}
+}
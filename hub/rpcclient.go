@@ -0,0 +1,157 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrRPCClientClosed is returned by calls made on, or waiting on, a closed RPCClient
+var ErrRPCClientClosed = errors.New("rpc client is closed")
+
+// RPCClient is a Go client for the event protocol. It can fire events and call RPC
+// handlers, awaiting their result. Events that are not replies are passed to onEvent
+type RPCClient struct {
+	conn *websocket.Conn
+	// onEvent is called from the reading goroutine for every event that is not a reply
+	onEvent func(Event)
+
+	// writeMu makes sure only one goroutine writes to the connection at a time
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[string]chan Event
+	// err is the reason the client stopped, set before done is closed
+	err  error
+	done chan struct{}
+}
+
+// Err returns the error that stopped the client once Done is closed
+func (rc *RPCClient) Err() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.err
+}
+
+// NewRPCClient starts reading from a already opened websocket connection.
+// onEvent may be nil if only RPCs are used
+func NewRPCClient(conn *websocket.Conn, onEvent func(Event)) *RPCClient {
+	rc := &RPCClient{
+		conn:    conn,
+		onEvent: onEvent,
+		pending: make(map[string]chan Event),
+		done:    make(chan struct{}),
+	}
+	go rc.readMessages()
+	return rc
+}
+
+// Send fires a event without waiting for anything
+func (rc *RPCClient) Send(eventType string, payload any) error {
+	return rc.write(context.Background(), eventType, "", payload)
+}
+
+// Call invokes the RPC eventType with params and unmarshals the reply into result,
+// result may be nil if the caller does not care. Errors sent by the server are
+// returned as *HandlerError. The trace context of ctx is sent along, so the server handles the call in the same trace
+func (rc *RPCClient) Call(ctx context.Context, eventType string, params any, result any) error {
+	reply := make(chan Event, 1)
+
+	select {
+	case <-rc.done:
+		return ErrRPCClientClosed
+	default:
+	}
+
+	rc.mu.Lock()
+	rc.nextID++
+	id := strconv.FormatUint(rc.nextID, 10)
+	rc.pending[id] = reply
+	rc.mu.Unlock()
+
+	defer func() {
+		rc.mu.Lock()
+		delete(rc.pending, id)
+		rc.mu.Unlock()
+	}()
+
+	if err := rc.write(ctx, eventType, id, params); err != nil {
+		return err
+	}
+
+	select {
+	case event := <-reply:
+		if event.Type == EventError {
+			var errEvent ErrorEvent
+			if err := event.Payload.Decode(&errEvent); err != nil {
+				return fmt.Errorf("bad error reply: %w", err)
+			}
+			return NewHandlerError(errEvent.Code, errEvent.Message)
+		}
+		if result == nil {
+			return nil
+		}
+		return event.Payload.Decode(result)
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-rc.done:
+		return ErrRPCClientClosed
+	}
+}
+
+// Close closes the connection, all pending calls fails with ErrRPCClientClosed
+func (rc *RPCClient) Close() error {
+	return rc.conn.Close()
+}
+
+// Done is closed once the connection has stopped
+func (rc *RPCClient) Done() <-chan struct{} {
+	return rc.done
+}
+
+// write marshals payload into a event and writes it to the connection
+func (rc *RPCClient) write(ctx context.Context, eventType, id string, payload any) error {
+	event := Event{Type: eventType, ID: id, Payload: NewPayload(payload)}.WithContext(ctx)
+
+	rc.writeMu.Lock()
+	defer rc.writeMu.Unlock()
+	return rc.conn.WriteJSON(event)
+}
+
+// readMessages routes replies to the waiting calls and everything else to onEvent
+func (rc *RPCClient) readMessages() {
+	var err error
+	for {
+		var event Event
+		if err = rc.conn.ReadJSON(&event); err != nil {
+			break
+		}
+
+		if event.ReplyTo != "" {
+			rc.mu.Lock()
+			reply, ok := rc.pending[event.ReplyTo]
+			rc.mu.Unlock()
+			if ok {
+				// Only the first reply counts, never let a duplicate block the reader
+				select {
+				case reply <- event:
+				default:
+				}
+				continue
+			}
+		}
+		if rc.onEvent != nil {
+			rc.onEvent(event)
+		}
+	}
+
+	rc.mu.Lock()
+	rc.err = err
+	rc.mu.Unlock()
+	close(rc.done)
+}
@@ -0,0 +1,221 @@
+package hub
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// EventSessionResumed is the first event on a resumed connection, it is followed by the replayed events
+	EventSessionResumed = "session_resumed"
+)
+
+var (
+	// ErrUnknownSession is returned when resuming a session that does not exist or has expired
+	ErrUnknownSession = errors.New("session is unknown or expired")
+	// errShuttingDown is returned by addClient once Shutdown has been called
+	errShuttingDown = errors.New("manager is shutting down")
+)
+
+// SessionResumedEvent is the payload of session_resumed
+type SessionResumedEvent struct {
+	// LastSeq is the last sequence number the client said it has seen
+	LastSeq uint64 `json:"last_seq"`
+	// Replayed is how many events follow that were missed
+	Replayed int `json:"replayed"`
+	// Gap is true when some events after LastSeq were dropped from the buffer and are lost
+	Gap bool `json:"gap"`
+}
+
+// session outlives a single connection. It is created by the login, numbers every event
+// sent to the user and keeps the latest ones so a reconnecting client can catch up.
+// While no connection is attached the last client stays in its room, so the session keeps
+// buffering what is said there until it is resumed or expires
+type session struct {
+	sync.Mutex
+	token    string
+	identity Identity
+	// lastSeq is the sequence number of the last event sent
+	lastSeq uint64
+	// buffer is a ring of the latest events, start is the oldest once it is full
+	buffer []Event
+	start  int
+	size   int
+	// client is the latest client of the session, attached is false once its connection is gone
+	client   *Client
+	attached bool
+	// expired is set when the session is removed, it can not be attached after that
+	expired bool
+	// expiry removes the session once it has been detached for too long
+	expiry *time.Timer
+}
+
+// send numbers the event, buffers it and queues it on the attached client.
+// Holding the lock while queueing keeps the events in sequence order
+func (s *session) send(event Event) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	s.lastSeq++
+	event.Seq = s.lastSeq
+	if len(s.buffer) < s.size {
+		s.buffer = append(s.buffer, event)
+	} else {
+		s.buffer[s.start] = event
+		s.start = (s.start + 1) % s.size
+	}
+
+	if !s.attached {
+		// Buffered for when the client comes back
+		return true
+	}
+	return s.client.enqueue(event)
+}
+
+// attach makes c the client of the session and returns the client it replaces.
+// When resuming, the buffered events after lastSeq are returned so they can be written before anything new,
+// gap tells if some of them were already dropped from the buffer
+func (s *session) attach(c *Client, resume bool, lastSeq uint64) (previous *Client, replay []Event, gap bool, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.expired {
+		return nil, nil, false, ErrUnknownSession
+	}
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+
+	if resume {
+		for i := 0; i < len(s.buffer); i++ {
+			event := s.buffer[(s.start+i)%len(s.buffer)]
+			if event.Seq > lastSeq {
+				replay = append(replay, event)
+			}
+		}
+		// The client missed more than we have if the oldest buffered is not the next it expects
+		switch {
+		case len(replay) > 0:
+			gap = replay[0].Seq > lastSeq+1
+		default:
+			gap = lastSeq < s.lastSeq
+		}
+	}
+
+	previous = s.client
+	s.client = c
+	s.attached = true
+	return previous, replay, gap, nil
+}
+
+// detach marks the session as disconnected if c is its client, and starts the expiry.
+// It returns false if c had already been replaced by a newer client
+func (s *session) detach(c *Client, ttl time.Duration, expire func()) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.client != c || s.expired {
+		return false
+	}
+	s.attached = false
+	s.expiry = time.AfterFunc(ttl, expire)
+	return true
+}
+
+// sessionRegistry holds the sessions by token
+type sessionRegistry struct {
+	sync.Mutex
+	sessions map[string]*session
+}
+
+// newSessionToken returns a random token that can not be guessed
+func newSessionToken() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// newSession creates a session for the identity. It has no client yet, so it expires
+// unless a websocket is opened within SessionTTL, or the OTP TTL if that is longer
+func (m *Manager) newSession(identity Identity) (*session, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return nil, err
+	}
+	s := &session{
+		token:    token,
+		identity: identity,
+		size:     m.config.SessionBufferSize,
+		buffer:   make([]Event, 0, m.config.SessionBufferSize),
+	}
+
+	m.sessions.Lock()
+	defer m.sessions.Unlock()
+
+	m.sessions.sessions[token] = s
+	ttl := m.config.SessionTTL
+	if m.config.OTPTTL > ttl {
+		ttl = m.config.OTPTTL
+	}
+	s.expiry = time.AfterFunc(ttl, func() { m.expireSession(s) })
+	return s, nil
+}
+
+// session returns the session of token
+func (m *Manager) session(token string) (*session, bool) {
+	m.sessions.Lock()
+	defer m.sessions.Unlock()
+
+	s, ok := m.sessions.sessions[token]
+	return s, ok
+}
+
+// expireSession removes a session that stayed detached for SessionTTL,
+// its last client finally leaves the room
+func (m *Manager) expireSession(s *session) {
+	m.sessions.Lock()
+	s.Lock()
+	if s.attached {
+		// Resumed just in time
+		s.Unlock()
+		m.sessions.Unlock()
+		return
+	}
+	s.expired = true
+	delete(m.sessions.sessions, s.token)
+	client := s.client
+	s.Unlock()
+	m.sessions.Unlock()
+
+	if client != nil {
+		_, changes := m.rooms.leaveRoom(client)
+		m.announce(changes)
+	}
+}
+
+// endSession removes the session at once, even while a client is attached. The attached client
+// leaves its room when it is removed, a client that is already gone leaves now
+func (m *Manager) endSession(s *session) {
+	m.sessions.Lock()
+	s.Lock()
+	s.expired = true
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	delete(m.sessions.sessions, s.token)
+	client, attached := s.client, s.attached
+	s.Unlock()
+	m.sessions.Unlock()
+
+	if client != nil && !attached {
+		_, changes := m.rooms.leaveRoom(client)
+		m.announce(changes)
+	}
+}
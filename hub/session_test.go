@@ -0,0 +1,198 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// loginSession logs in and returns the OTP and the session token
+func loginSession(t *testing.T, srv *httptest.Server, username string) (string, string) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"username": username, "password": "123"})
+	resp, err := http.Post(srv.URL+"/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var data struct {
+		OTP     string `json:"otp"`
+		Session string `json:"session"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil || data.Session == "" {
+		t.Fatalf("expected a otp and session from the login, got %+v %v", data, err)
+	}
+	return data.OTP, data.Session
+}
+
+// resume reconnects to the session, telling the server the last seq seen
+func resume(srv *httptest.Server, session string, lastSeq uint64) (*websocket.Conn, *http.Response, error) {
+	url := fmt.Sprintf("ws%s/ws?session=%s&last_seq=%d", strings.TrimPrefix(srv.URL, "http"), session, lastSeq)
+	header := http.Header{}
+	header.Set("Origin", "https://localhost:8080")
+	return websocket.DefaultDialer.Dial(url, header)
+}
+
+// readMessages reads n new_message events and returns their text and the seq of the last one
+func readMessages(t *testing.T, conn *websocket.Conn, n int) ([]string, uint64) {
+	t.Helper()
+	var texts []string
+	var lastSeq uint64
+	for len(texts) < n {
+		event := readEvent(t, conn)
+		if event.Type != EventNewMessage {
+			continue
+		}
+		if event.Seq <= lastSeq {
+			t.Fatalf("seq went from %d to %d", lastSeq, event.Seq)
+		}
+		lastSeq = event.Seq
+		var msg NewMessageEvent
+		event.Payload.Decode(&msg)
+		texts = append(texts, msg.Message)
+	}
+	return texts, lastSeq
+}
+
+func TestSession_ResumeReplaysMissedEvents(t *testing.T) {
+	m, srv := newTestServer(t)
+	percy := connect(t, srv, "percy")
+	otp, session := loginSession(t, srv, "anna")
+	anna, _, err := dial(srv, otp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForClients(t, m, 2)
+
+	sendEvent(t, percy, EventSendMessage, SendMessageEvent{Message: "1"})
+	_, lastSeq := readMessages(t, anna, 1)
+
+	// Drop the connection without a close frame, like a dead network would
+	anna.Close()
+	waitForClients(t, m, 1)
+	for _, text := range []string{"2", "3", "4"} {
+		sendEvent(t, percy, EventSendMessage, SendMessageEvent{Message: text})
+	}
+	readMessages(t, percy, 4)
+
+	anna, _, err = resume(srv, session, lastSeq)
+	if err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+	defer anna.Close()
+
+	var resumed SessionResumedEvent
+	readUntil(t, anna, EventSessionResumed, &resumed)
+	if resumed.LastSeq != lastSeq || resumed.Replayed != 3 || resumed.Gap {
+		t.Errorf("unexpected resume %+v", resumed)
+	}
+	texts, replayedSeq := readMessages(t, anna, 3)
+	if fmt.Sprint(texts) != "[2 3 4]" || replayedSeq != lastSeq+3 {
+		t.Errorf("expected 2, 3 and 4 replayed up to seq %d, got %v up to %d", lastSeq+3, texts, replayedSeq)
+	}
+
+	// And then it continues live, in the same room
+	sendEvent(t, percy, EventSendMessage, SendMessageEvent{Message: "5"})
+	event := readEvent(t, anna)
+	if event.Type != EventNewMessage || event.Seq != replayedSeq+1 {
+		t.Errorf("expected the live message with seq %d, got %s %d", replayedSeq+1, event.Type, event.Seq)
+	}
+	if members := m.rooms.Members(DefaultRoom); len(members) != 2 {
+		t.Errorf("expected the resumed client to replace the old one, got %d members", len(members))
+	}
+}
+
+func TestSession_ResumeGap(t *testing.T) {
+	m, srv := newTestServer(t, WithSessions(time.Minute, 2))
+	percy := connect(t, srv, "percy")
+	otp, session := loginSession(t, srv, "anna")
+	anna, _, err := dial(srv, otp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForClients(t, m, 2)
+	anna.Close()
+	waitForClients(t, m, 1)
+
+	for i := 1; i <= 4; i++ {
+		sendEvent(t, percy, EventSendMessage, SendMessageEvent{Message: fmt.Sprint(i)})
+	}
+	readMessages(t, percy, 4)
+
+	anna, _, err = resume(srv, session, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer anna.Close()
+	var resumed SessionResumedEvent
+	readUntil(t, anna, EventSessionResumed, &resumed)
+	if resumed.Replayed != 2 || !resumed.Gap {
+		t.Errorf("expected only the last 2 replayed with a gap, got %+v", resumed)
+	}
+	if texts, _ := readMessages(t, anna, 2); fmt.Sprint(texts) != "[3 4]" {
+		t.Errorf("expected the newest messages, got %v", texts)
+	}
+}
+
+func TestSession_ResumeTakesOverLiveConnection(t *testing.T) {
+	m, srv := newTestServer(t)
+	otp, session := loginSession(t, srv, "anna")
+	old, _, err := dial(srv, otp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	waitForClients(t, m, 1)
+
+	current, _, err := resume(srv, session, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer current.Close()
+
+	// The old connection is told it has been replaced
+	old.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for err == nil {
+		_, _, err = old.ReadMessage()
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseNormalClosure {
+		t.Fatalf("expected the old connection to be closed, got %v", err)
+	}
+	waitForClients(t, m, 1)
+	if members := m.rooms.Members(DefaultRoom); len(members) != 1 {
+		t.Errorf("expected a single member after the take over, got %d", len(members))
+	}
+}
+
+func TestSession_Expires(t *testing.T) {
+	m, srv := newTestServer(t, WithOTPTTL(50*time.Millisecond), WithSessions(50*time.Millisecond, 16))
+
+	if _, _, err := resume(srv, "made-up", 0); err == nil {
+		t.Fatal("expected a unknown session to be refused")
+	}
+
+	otp, session := loginSession(t, srv, "anna")
+	anna, _, err := dial(srv, otp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForClients(t, m, 1)
+	anna.Close()
+
+	// The detached client stays in the room until the session expires
+	waitFor(t, "the session to expire", func() bool { return len(m.rooms.Members(DefaultRoom)) == 0 })
+	_, resp, err := resume(srv, session, 0)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a expired session to be refused, got %v %v", resp, err)
+	}
+}
@@ -0,0 +1,69 @@
+package hub
+
+import (
+	"context"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// EventServerGoingAway is sent to every client right before the server closes the connection
+	EventServerGoingAway = "server_going_away"
+)
+
+// shutdownReason is sent in the going away event and the close frame
+const shutdownReason = "server is shutting down"
+
+// ServerGoingAwayEvent is the payload of EventServerGoingAway
+type ServerGoingAwayEvent struct {
+	Reason string `json:"reason"`
+}
+
+// isClosing reports if Shutdown has been called
+func (m *Manager) isClosing() bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.closing
+}
+
+// Shutdown stops accepting new websockets, tells every client the server is going away
+// and closes them with 1001. It waits for the writers to flush what is queued until ctx is done,
+// after that the remaining connections are cut and ctx.Err is returned.
+// The OTP retention goroutine is stopped as well, the manager can not be used after Shutdown
+func (m *Manager) Shutdown(ctx context.Context) error {
+	// Refuse new clients before taking the snapshot so nobody slips through
+	m.Lock()
+	m.closing = true
+	clients := make([]*Client, 0, len(m.clients))
+	for client := range m.clients {
+		clients = append(clients, client)
+	}
+	m.Unlock()
+
+	m.cancel()
+
+	goingAway := Event{Type: EventServerGoingAway, Payload: NewPayload(ServerGoingAwayEvent{Reason: shutdownReason})}
+	for _, client := range clients {
+		client.Send(goingAway)
+		client.close(websocket.CloseGoingAway, shutdownReason)
+	}
+
+	flushed := make(chan struct{})
+	go func() {
+		m.writers.Wait()
+		close(flushed)
+	}()
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		m.logger.Warn("shutdown deadline reached, closing remaining connections", "connections", len(clients))
+		// Closing the connection makes any blocked write fail so the writers exit
+		for _, client := range clients {
+			client.connection.Close()
+		}
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,61 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestManager_Shutdown(t *testing.T) {
+	m, srv := newTestServer(t)
+
+	percy := connect(t, srv, "percy")
+	anna := connect(t, srv, "anna")
+	waitForClients(t, m, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	for _, conn := range []*websocket.Conn{percy, anna} {
+		event := readEvent(t, conn)
+		if event.Type != EventServerGoingAway {
+			t.Fatalf("expected %s, got %s", EventServerGoingAway, event.Type)
+		}
+		var payload ServerGoingAwayEvent
+		if err := event.Payload.Decode(&payload); err != nil || payload.Reason == "" {
+			t.Fatalf("bad going away payload %s: %v", event.Payload, err)
+		}
+
+		_, _, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+			t.Fatalf("expected close 1001, got %v", err)
+		}
+	}
+
+	if count := m.ClientCount(); count != 0 {
+		t.Fatalf("expected no clients after shutdown, got %d", count)
+	}
+	select {
+	case <-m.ctx.Done():
+	default:
+		t.Fatal("the manager context, and with it the OTP retention, was not stopped")
+	}
+
+	// Logins still answer, but no new websockets are accepted
+	otp, status := login(t, srv, "percy", "123")
+	if status != http.StatusOK {
+		t.Fatalf("expected login to work, got %d", status)
+	}
+	_, resp, err := dial(srv, otp)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 after shutdown, got %v %v", resp, err)
+	}
+}
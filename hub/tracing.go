@@ -0,0 +1,102 @@
+package hub
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans of the hub
+const tracerName = "programmingpercy.tech/websockets-go/hub"
+
+// propagator reads and writes the W3C trace context, in HTTP headers and in Event.Metadata
+var propagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// Context returns the context a received event is handled in. It carries the span of the
+// handler, pass it to WithContext for the events sent while handling it
+func (e Event) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// WithContext returns a copy of the event carrying the trace context of ctx in its Metadata,
+// so the spans of writing it to the clients become children of the span in ctx
+func (e Event) WithContext(ctx context.Context) Event {
+	metadata := make(map[string]string, len(e.Metadata)+2)
+	for key, value := range e.Metadata {
+		metadata[key] = value
+	}
+	propagator.Inject(ctx, propagation.MapCarrier(metadata))
+	if len(metadata) > 0 {
+		e.Metadata = metadata
+	}
+	return e
+}
+
+// traceContext returns a context holding the trace context in the metadata of the event, if any
+func (e Event) traceContext() context.Context {
+	return propagator.Extract(context.Background(), propagation.MapCarrier(e.Metadata))
+}
+
+// handle routes a received event in a span of its own and answers it. The span is a child of the
+// trace context the client put in the metadata, the ack or error carries the span back to the client
+func (c *Client) handle(request Event) {
+	ctx, span := c.manager.tracer.Start(request.traceContext(), "event "+request.Type,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("event.type", request.Type),
+			attribute.String("event.id", request.ID),
+			attribute.String("user", c.identity.Username),
+			attribute.String("conn", c.id),
+		))
+	defer span.End()
+	request.ctx = ctx
+
+	if err := c.manager.routeEvent(request, c); err != nil {
+		c.log(slog.LevelWarn, "failed to handle event", "event", request.Type, "err", err)
+		spanError(span, err)
+		c.sendError(request, err)
+		return
+	}
+	// Only events with a id expects a ack, RPCs are answered by their handler
+	if request.ID != "" && !c.manager.isRPC(request.Type) {
+		c.sendAck(request)
+	}
+}
+
+// traceWrite starts the span of writing event to the client. Only events that carry a trace
+// context are traced, anything else would start a new trace for every frame
+func (c *Client) traceWrite(event Event) trace.Span {
+	ctx := event.traceContext()
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return trace.SpanFromContext(ctx)
+	}
+	_, span := c.manager.tracer.Start(ctx, "write "+event.Type,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("event.type", event.Type),
+			attribute.String("user", c.identity.Username),
+			attribute.String("conn", c.id),
+		))
+	return span
+}
+
+// startHTTPSpan starts the span of a HTTP request, it is a child of the trace context in the headers
+func (m *Manager) startHTTPSpan(r *http.Request, name string) trace.Span {
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	_, span := m.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+	return span
+}
+
+// spanError marks the span as failed because of err
+func spanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
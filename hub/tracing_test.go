@@ -0,0 +1,102 @@
+package hub
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const (
+	// clientTrace and clientSpan are the trace context a traced frontend would send
+	clientTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+	clientSpan  = "00f067aa0ba902b7"
+)
+
+// findSpan returns the ended span called name
+func findSpan(exporter *tracetest.InMemoryExporter, name string) (tracetest.SpanStub, bool) {
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			return span, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+func TestManager_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	m, srv := newTestServer(t, WithTracerProvider(provider))
+
+	conn := connect(t, srv, "percy")
+	waitForClients(t, m, 1)
+
+	request := Event{
+		Type:     EventSendMessage,
+		ID:       "1",
+		Payload:  NewPayload(SendMessageEvent{Message: "traced"}),
+		Metadata: map[string]string{"traceparent": "00-" + clientTrace + "-" + clientSpan + "-01"},
+	}
+	if err := conn.WriteJSON(request); err != nil {
+		t.Fatal(err)
+	}
+	// Both the broadcast and the reply carry the trace of the request
+	for _, eventType := range []string{EventNewMessage, EventAck} {
+		event := readUntil(t, conn, eventType, nil, EventError)
+		if !strings.Contains(event.Metadata["traceparent"], clientTrace) {
+			t.Errorf("expected %s to carry the trace of the request, got %v", eventType, event.Metadata)
+		}
+	}
+
+	waitFor(t, "the writes to be traced", func() bool {
+		_, ack := findSpan(exporter, "write "+EventAck)
+		_, message := findSpan(exporter, "write "+EventNewMessage)
+		return ack && message
+	})
+	handled, ok := findSpan(exporter, "event "+EventSendMessage)
+	if !ok {
+		t.Fatal("expected a span for handling the event")
+	}
+	if handled.SpanContext.TraceID().String() != clientTrace || handled.Parent.SpanID().String() != clientSpan {
+		t.Errorf("expected the handler span to be a child of the client span, got parent %s", handled.Parent.SpanID())
+	}
+	for _, name := range []string{"write " + EventAck, "write " + EventNewMessage} {
+		write, _ := findSpan(exporter, name)
+		if write.Parent.SpanID() != handled.SpanContext.SpanID() {
+			t.Errorf("expected %s to be a child of the handler span", name)
+		}
+	}
+	for _, name := range []string{"login", "upgrade"} {
+		if _, ok := findSpan(exporter, name); !ok {
+			t.Errorf("expected a %s span", name)
+		}
+	}
+	// Presence carries no trace, so it does not start traces of its own
+	if _, ok := findSpan(exporter, "write "+EventPresenceSnapshot); ok {
+		t.Error("expected untraced events to be written without a span")
+	}
+}
+
+func TestManager_TracingRPC(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	m, srv := newTestServer(t, WithTracerProvider(provider))
+
+	conn := connect(t, srv, "percy")
+	waitForClients(t, m, 1)
+	call(t, conn, "1", EventListRooms, nil)
+	reply := readUntil(t, conn, EventReply, nil, EventError)
+
+	waitFor(t, "the rpc to be traced", func() bool {
+		_, ok := findSpan(exporter, "rpc "+EventListRooms)
+		return ok
+	})
+	rpc, _ := findSpan(exporter, "rpc "+EventListRooms)
+	if !strings.Contains(reply.Metadata["traceparent"], rpc.SpanContext.SpanID().String()) {
+		t.Errorf("expected the reply to be linked to the rpc span, got %v", reply.Metadata)
+	}
+}
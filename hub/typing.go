@@ -0,0 +1,96 @@
+package hub
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// EventTypingStart is sent by a client when its user starts typing, and fanned out to the room
+	EventTypingStart = "typing_start"
+	// EventTypingStop is sent by a client when its user stops typing, the server sends it by itself
+	// when no stop arrives within the typing timeout
+	EventTypingStop = "typing_stop"
+)
+
+// TypingEvent is the payload of typing_start and typing_stop sent to the room
+type TypingEvent struct {
+	Room     string `json:"room"`
+	Username string `json:"username"`
+}
+
+// typingState tracks if a client is typing, so starts can be throttled and expired
+type typingState struct {
+	sync.Mutex
+	// room is where the client is typing, empty when it is not
+	room string
+	// announced is when typing_start was last fanned out
+	announced time.Time
+	// expiry sends the stop if the client never does
+	expiry *time.Timer
+}
+
+// TypingStartHandler fans out typing_start to the room of the client.
+// Repeated starts are throttled, they only keep the indicator alive
+func TypingStartHandler(event Event, c *Client) error {
+	room, ok := c.manager.rooms.RoomOf(c)
+	if !ok {
+		return ErrNotInRoom
+	}
+
+	cfg := c.manager.config
+	c.typing.Lock()
+	stale := ""
+	if c.typing.room != "" && c.typing.room != room {
+		// Moved rooms without stopping, the old room should not wait for the timeout
+		c.typing.expiry.Stop()
+		stale = c.typing.room
+		c.typing.room = ""
+	}
+	announce := c.typing.room == "" || time.Since(c.typing.announced) >= cfg.TypingThrottle
+	if c.typing.room == "" {
+		c.typing.expiry = time.AfterFunc(cfg.TypingTimeout, c.stopTyping)
+	} else {
+		c.typing.expiry.Reset(cfg.TypingTimeout)
+	}
+	c.typing.room = room
+	if announce {
+		c.typing.announced = time.Now()
+	}
+	c.typing.Unlock()
+
+	if stale != "" {
+		c.manager.sendTyping(EventTypingStop, stale, c.identity.Username)
+	}
+	if announce {
+		c.manager.sendTyping(EventTypingStart, room, c.identity.Username)
+	}
+	return nil
+}
+
+// TypingStopHandler fans out typing_stop if the client was typing
+func TypingStopHandler(event Event, c *Client) error {
+	c.stopTyping()
+	return nil
+}
+
+// stopTyping ends the typing indicator of the client, it does nothing if the client is not typing.
+// It is also run by the expiry when the client never sends a stop
+func (c *Client) stopTyping() {
+	c.typing.Lock()
+	room := c.typing.room
+	if room != "" {
+		c.typing.expiry.Stop()
+		c.typing.room = ""
+	}
+	c.typing.Unlock()
+
+	if room != "" {
+		c.manager.sendTyping(EventTypingStop, room, c.identity.Username)
+	}
+}
+
+// sendTyping fans out a typing event to the other users in the room
+func (m *Manager) sendTyping(eventType, room, username string) {
+	m.broadcastOthers(room, username, Event{Type: eventType, Payload: NewPayload(TypingEvent{Room: room, Username: username})})
+}
@@ -0,0 +1,65 @@
+package hub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestManager_TypingFansOutToRoom(t *testing.T) {
+	auth := NewMemoryAuthenticator()
+	for _, username := range []string{"percy", "anna", "bob"} {
+		auth.AddUser(username, "123")
+	}
+	m, srv := newTestServerWithAuth(t, auth, WithTyping(time.Minute, time.Minute))
+	percy := connect(t, srv, "percy")
+	anna := connect(t, srv, "anna")
+	bob := connect(t, srv, "bob")
+	waitForClients(t, m, 3)
+
+	sendEvent(t, bob, EventChangeRoom, ChangeRoomEvent{Name: "games"})
+	waitFor(t, "bob to change room", func() bool { return len(m.rooms.Members("games")) == 1 })
+
+	// The second start is throttled, so only a single typing_start reaches anna
+	sendEvent(t, percy, EventTypingStart, nil)
+	sendEvent(t, percy, EventTypingStart, nil)
+	sendEvent(t, percy, EventTypingStop, nil)
+	sendEvent(t, percy, EventSendMessage, SendMessageEvent{Message: "hi"})
+
+	var typing TypingEvent
+	readUntil(t, anna, EventTypingStart, &typing)
+	if typing.Username != "percy" || typing.Room != DefaultRoom {
+		t.Errorf("unexpected typing_start %+v", typing)
+	}
+	readUntil(t, anna, EventTypingStop, &typing, EventTypingStart)
+	if event := readEvent(t, anna); event.Type != EventNewMessage {
+		t.Errorf("expected the message after a single start and stop, got %s: %s", event.Type, event.Payload)
+	}
+
+	// Neither the sender nor other rooms hear about it, bob reads up to his own message
+	sendEvent(t, bob, EventSendMessage, SendMessageEvent{Message: "anyone?"})
+	for _, conn := range []*websocket.Conn{percy, bob} {
+		for event := readEvent(t, conn); event.Type != EventNewMessage; event = readEvent(t, conn) {
+			if event.Type == EventTypingStart || event.Type == EventTypingStop {
+				t.Errorf("expected no typing events, got %s: %s", event.Type, event.Payload)
+			}
+		}
+	}
+}
+
+func TestManager_TypingExpires(t *testing.T) {
+	m, srv := newTestServer(t, WithTyping(50*time.Millisecond, time.Minute))
+	percy := connect(t, srv, "percy")
+	anna := connect(t, srv, "anna")
+	waitForClients(t, m, 2)
+
+	sendEvent(t, percy, EventTypingStart, nil)
+	var typing TypingEvent
+	readUntil(t, anna, EventTypingStart, &typing)
+	// No stop is sent, the server gives up on its own
+	readUntil(t, anna, EventTypingStop, &typing, EventTypingStart)
+	if typing.Username != "percy" {
+		t.Errorf("expected percy to stop typing, got %+v", typing)
+	}
+}
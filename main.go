@@ -2,12 +2,36 @@ package main //@包主
 
 import ( //@进口
 	"context" //@语境
+	"errors"
+	"flag"
 	"fmt" //@调速器
 	"log" //@日志
+	"log/slog"
 	"net/http" //@净http
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"programmingpercy.tech/websockets-go/hub"
+	"programmingpercy.tech/websockets-go/sqlite"
 )
 
 func main() { //@主要功能
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run starts the server and blocks until it is stopped. Errors are returned instead of exiting,
+// so the deferred closes and the span flush always run
+func run() error {
 
 	// Create a root ctx and a CancelFunc which can be used to cancel retentionMap goroutine //@创建一个根 ctx 和一个可用于取消保留映射 goroutine 的取消函数
 	rootCtx := context.Background() //@根ctx上下文背景
@@ -15,28 +39,212 @@ func main() { //@主要功能
 
 	defer cancel() //@推迟取消
 
-	setupAPI(ctx) //@设置 api 我 ctx
+	usersFile := flag.String("users", "", "path to a JSON user file with bcrypt hashed passwords")
+	htpasswdFile := flag.String("htpasswd", "", "path to a htpasswd file with bcrypt or {SHA} passwords")
+	historyFile := flag.String("history-file", "", "path to a file to keep the chat history in, direct messages go to the same path with .direct added. It is kept in memory if empty")
+	dbFile := flag.String("db", "", "path to a SQLite database keeping users, rooms, messages and blocks across restarts")
+	addUser := flag.String("add-user", "", "add username:password[:role,role] to the -db and exit")
+	// Settings are read from -config, then WS_ environment variables, then flags
+	cfg, err := hub.LoadConfig(flag.CommandLine, os.Args[1:], "WS_")
+	if err != nil {
+		return err
+	}
+	// Both would keep the history, the database already has it
+	if *historyFile != "" && *dbFile != "" {
+		return errors.New("-history-file and -db can not be used together, the -db keeps the history")
+	}
+
+	var db *sqlite.DB
+	if *dbFile != "" {
+		db, err = sqlite.Open(*dbFile)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		cfg.History = db.Messages()
+		cfg.RoomStore = db.Rooms()
+		cfg.DirectHistory = db.DirectMessages()
+		cfg.Blocks = db.Blocks()
+	}
+	if *addUser != "" {
+		return addDBUser(db, *addUser)
+	}
+
+	auth, err := setupAuthenticator(*usersFile, *htpasswdFile, db)
+	if err != nil {
+		return err
+	}
+
+	if *historyFile != "" {
+		history, err := hub.NewFileHistory(*historyFile)
+		if err != nil {
+			return err
+		}
+		defer history.Close()
+		cfg.History = history
+		// A file of their own, so a room can never be named like a conversation
+		directHistory, err := hub.NewFileHistory(*historyFile + ".direct")
+		if err != nil {
+			return err
+		}
+		defer directHistory.Close()
+		cfg.DirectHistory = directHistory
+	}
+
+	// Structured logs, every line about a connection carries its conn id, user, room and remote address
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel}))
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := setupTracing(ctx, cfg.OTLPEndpoint)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Send what is left before exiting
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelFlush()
+		if err := shutdownTracing(flushCtx); err != nil {
+			slog.Error("failed to flush spans", "err", err)
+		}
+	}()
+
+	manager, err := setupAPI(ctx, cfg, auth)
+	if err != nil {
+		return err
+	}
+
+	// Stop on ctrl-c or when the process is asked to terminate
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Serve on the configured port, no more hardcoded port
+	server := &http.Server{Addr: cfg.Addr}
+	serveErr := make(chan error, 2)
+	go func() {
+		serveErr <- server.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+	}()
+	// Metrics are served apart from the public listener, so only who can reach MetricsAddr sees them
+	metricsServer := setupMetrics(cfg.MetricsAddr, manager)
+	if metricsServer != nil {
+		go func() {
+			serveErr <- metricsServer.ListenAndServe()
+		}()
+	}
 
-	// Serve on port :8080, fudge yeah hardcoded port //@在端口软糖上服务是的硬编码端口
-	err := http.ListenAndServeTLS(":8080", "server.crt", "server.key", nil) //@错误的 http 监听和服务 tl s 服务器 crt 服务器密钥 nil
-	if err != nil { //@如果错误为零
-		log.Fatal("ListenAndServe: ", err) //@记录致命的监听和服务错误
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("failed to serve: %w", err)
+	case <-ctx.Done():
 	}
 
+	slog.Info("shutting down")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelShutdown()
+	// Drain the websockets first, Server.Shutdown does not know about hijacked connections
+	if err := manager.Shutdown(shutdownCtx); err != nil {
+		slog.Error("websocket shutdown failed", "err", err)
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("http shutdown failed", "err", err)
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("metrics shutdown failed", "err", err)
+		}
+	}
+	return nil
+}
+
+// setupMetrics returns the server of /metrics listening on addr, or nil if addr is empty
+func setupMetrics(addr string, manager *hub.Manager) *http.Server {
+	if addr == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", manager.MetricsHandler)
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+// addDBUser adds the user described as username:password[:role,role] to the database
+func addDBUser(db *sqlite.DB, user string) error {
+	if db == nil {
+		return errors.New("-add-user needs a -db to add the user to")
+	}
+	parts := strings.SplitN(user, ":", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return errors.New("-add-user expects username:password[:role,role]")
+	}
+	var roles []string
+	if len(parts) == 3 && parts[2] != "" {
+		roles = strings.Split(parts[2], ",")
+	}
+	return db.Users().AddUser(parts[0], parts[1], roles...)
+}
+
+// setupAuthenticator picks the backend used to verify logins, defaults to the example user percy
+func setupAuthenticator(usersFile, htpasswdFile string, db *sqlite.DB) (hub.Authenticator, error) {
+	var auth hub.Authenticator
+	switch {
+	case usersFile != "":
+		fileAuth, err := hub.NewFileAuthenticator(usersFile)
+		if err != nil {
+			return nil, err
+		}
+		auth = fileAuth
+	case htpasswdFile != "":
+		htpasswdAuth, err := hub.NewHtpasswdAuthenticator(htpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		auth = htpasswdAuth
+	case db != nil:
+		auth = db.Users()
+	default:
+		memoryAuth := hub.NewMemoryAuthenticator()
+		// The example user is publicly known, so it is never a admin. Admins come from -users or -db
+		memoryAuth.AddUser("percy", "123")
+		auth = memoryAuth
+	}
+	// Stop users from guessing passwords
+	return hub.NewLockoutAuthenticator(auth, 5, time.Minute), nil
+}
+
+// setupTracing exports the spans of the hub over OTLP/HTTP to endpoint, nothing is traced if it is empty.
+// The returned function flushes and stops the exporter
+func setupTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the OTLP exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "websockets-go"))),
+	)
+	// The hub uses the global provider unless it is given one
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
 }
 
 // setupAPI will start all Routes and their Handlers //@设置 ap 我将启动所有路由及其处理程序
-func setupAPI(ctx context.Context) { //@func setup ap i ctx context 上下文
+// It returns the manager so it can be shut down
+func setupAPI(ctx context.Context, cfg hub.Config, auth hub.Authenticator) (*hub.Manager, error) {
 
 	// Create a Manager instance used to handle WebSocket Connections //@创建用于处理 Web 套接字连接的管理器实例
-	manager := NewManager(ctx) //@经理新经理ctx
+	manager, err := hub.NewManager(ctx, auth, hub.WithConfig(cfg), hub.WithLogger(slog.Default()))
+	if err != nil {
+		return nil, err
+	}
+	// Never let a broken handler take the server down, and log what is handled
+	manager.Use(hub.RecoveryMiddleware(), hub.LoggingMiddleware(nil))
 
 	// Serve the ./frontend directory at Route / //@在路由中提供前端目录
 	http.Handle("/", http.FileServer(http.Dir("./frontend"))) //@http 句柄 http 文件服务器 http dir 前端
-	http.HandleFunc("/login", manager.loginHandler) //@http 句柄 func 登录管理器登录处理程序
-	http.HandleFunc("/ws", manager.serveWS)
-
-	http.HandleFunc("/debug", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, len(manager.clients)) //@fmt fprint w len 经理客户
-	})
+	http.HandleFunc("/login", manager.LoginHandler)
+	http.HandleFunc("/ws", manager.ServeWS)
+	// Inspect and manage the connections, only users with the admin role are let in
+	http.Handle("/admin/", http.StripPrefix("/admin", manager.AdminHandler()))
+	return manager, nil
 }
@@ -0,0 +1,92 @@
+// Package sqlite stores users, rooms, memberships, messages and blocks of the hub in a SQLite file,
+// so they survive restarts. It uses a pure Go driver, so no C compiler or external service is needed.
+//
+//	db, err := sqlite.Open("chat.db")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer db.Close()
+//
+//	manager, err := hub.NewManager(ctx, db.Users(),
+//		hub.WithHistory(db.Messages(), 50),
+//		hub.WithRoomStore(db.Rooms()),
+//		hub.WithDirectHistory(db.DirectMessages()),
+//		hub.WithBlockList(db.Blocks()),
+//	)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+
+	// Registers the pure Go sqlite driver
+	_ "modernc.org/sqlite"
+)
+
+// DB is a open SQLite database with the schema migrated to the latest version
+type DB struct {
+	db *sql.DB
+}
+
+// Open opens or creates the database file at path and runs any missing migrations.
+// Use ":memory:" for a database that lives as long as the DB
+func Open(path string) (*DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows one writer, using a single connection avoids busy errors
+	// and keeps a :memory: database from being opened once per connection
+	db.SetMaxOpenConns(1)
+
+	pragmas := []string{
+		"PRAGMA foreign_keys = ON",
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA busy_timeout = 5000",
+	}
+	for _, pragma := range pragmas {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to set %q: %w", pragma, err)
+		}
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+// Close closes the database
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Users returns the repository of user accounts, it is a hub.Authenticator
+func (d *DB) Users() *Users {
+	return &Users{db: d.db}
+}
+
+// Rooms returns the repository of rooms and memberships, it is a hub.RoomStore
+func (d *DB) Rooms() *Rooms {
+	return &Rooms{db: d.db}
+}
+
+// Messages returns the repository of chat messages, it is a hub.HistoryStore
+func (d *DB) Messages() *Messages {
+	return &Messages{db: d.db}
+}
+
+// DirectMessages returns the repository of direct messages, it is a hub.HistoryStore
+func (d *DB) DirectMessages() *DirectMessages {
+	return &DirectMessages{db: d.db}
+}
+
+// Blocks returns the repository of blocked users, it is a hub.BlockList
+func (d *DB) Blocks() *Blocks {
+	return &Blocks{db: d.db}
+}
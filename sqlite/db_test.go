@@ -0,0 +1,47 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestDB opens a new database in a temp dir, closed when the test ends
+func openTestDB(t *testing.T) (*DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "chat.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, path
+}
+
+func TestOpen_Migrations(t *testing.T) {
+	db, path := openTestDB(t)
+
+	var version int
+	if err := db.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Fatalf("expected schema version %d, got %d", len(migrations), version)
+	}
+	db.Close()
+
+	// Opening again should not try to run the migrations twice
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopening failed: %v", err)
+	}
+	// A database written by a newer binary is refused
+	if _, err := db.db.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, len(migrations)+1); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if _, err := Open(path); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("expected a too new schema to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"programmingpercy.tech/websockets-go/hub"
+)
+
+// DirectMessages is the repository of direct messages, each conversation numbers its messages from 1.
+// They are kept apart from the room messages so conversations never show up as rooms
+type DirectMessages struct {
+	db *sql.DB
+}
+
+var _ hub.HistoryStore = (*DirectMessages)(nil)
+
+// Append stores the message as the next message of the conversation
+func (d *DirectMessages) Append(conversation string, msg hub.NewMessageEvent) (hub.HistoryMessage, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return hub.HistoryMessage{}, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(id), 0) + 1 FROM direct_messages WHERE conversation = ?`, conversation).Scan(&id); err != nil {
+		return hub.HistoryMessage{}, err
+	}
+	_, err = tx.Exec(`INSERT INTO direct_messages (conversation, id, sender, body, sent_at) VALUES (?, ?, ?, ?, ?)`,
+		conversation, id, msg.From, msg.Message, msg.Sent.UnixNano())
+	if err != nil {
+		return hub.HistoryMessage{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return hub.HistoryMessage{}, err
+	}
+	return hub.HistoryMessage{ID: id, NewMessageEvent: msg}, nil
+}
+
+// Before returns up to limit messages of the conversation with an ID lower than before, oldest first.
+// A before of 0 returns the newest messages
+func (d *DirectMessages) Before(conversation string, before int64, limit int) ([]hub.HistoryMessage, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	if before <= 0 {
+		before = 1<<63 - 1
+	}
+	rows, err := d.db.Query(`SELECT id, sender, body, sent_at FROM direct_messages
+		WHERE conversation = ? AND id < ?
+		ORDER BY id DESC LIMIT ?`, conversation, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanHistory(rows)
+}
+
+// Blocks is the repository of the users each user has blocked
+type Blocks struct {
+	db *sql.DB
+}
+
+var _ hub.BlockList = (*Blocks)(nil)
+
+// Block stops blocked from sending direct messages to username, blocking twice is not an error
+func (b *Blocks) Block(username, blocked string) error {
+	_, err := b.db.Exec(`INSERT INTO blocks (username, blocked, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (username, blocked) DO NOTHING`, username, blocked, time.Now().UnixNano())
+	return err
+}
+
+// Unblock allows blocked to send direct messages to username again
+func (b *Blocks) Unblock(username, blocked string) error {
+	_, err := b.db.Exec(`DELETE FROM blocks WHERE username = ? AND blocked = ?`, username, blocked)
+	return err
+}
+
+// IsBlocked reports if username has blocked sender
+func (b *Blocks) IsBlocked(username, sender string) (bool, error) {
+	var blocked bool
+	err := b.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM blocks WHERE username = ? AND blocked = ?)`, username, sender).Scan(&blocked)
+	return blocked, err
+}
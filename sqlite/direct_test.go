@@ -0,0 +1,58 @@
+package sqlite
+
+import (
+	"testing"
+	"time"
+
+	"programmingpercy.tech/websockets-go/hub"
+)
+
+func TestDirectMessages_KeptApartFromRooms(t *testing.T) {
+	db, _ := openTestDB(t)
+	direct := db.DirectMessages()
+
+	for _, text := range []string{"hi", "hello"} {
+		if _, err := direct.Append("dm:anna:percy", hub.NewMessageEvent{SendMessageEvent: hub.SendMessageEvent{Message: text, From: "percy"}, Sent: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page, err := direct.Before("dm:anna:percy", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[0].ID != 1 || page[1].Message != "hello" {
+		t.Errorf("unexpected conversation %+v", page)
+	}
+
+	// A conversation is not a room
+	rooms, err := db.Rooms().Rooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 0 {
+		t.Errorf("expected no rooms, got %v", rooms)
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	db, _ := openTestDB(t)
+	blocks := db.Blocks()
+
+	for i := 0; i < 2; i++ {
+		if err := blocks.Block("anna", "percy"); err != nil {
+			t.Fatalf("blocking twice should be fine: %v", err)
+		}
+	}
+	if blocked, err := blocks.IsBlocked("anna", "percy"); !blocked || err != nil {
+		t.Errorf("expected anna to have blocked percy, got %v %v", blocked, err)
+	}
+	if blocked, _ := blocks.IsBlocked("percy", "anna"); blocked {
+		t.Error("blocking should only go one way")
+	}
+	if err := blocks.Unblock("anna", "percy"); err != nil {
+		t.Fatal(err)
+	}
+	if blocked, _ := blocks.IsBlocked("anna", "percy"); blocked {
+		t.Error("expected percy to be unblocked")
+	}
+}
@@ -0,0 +1,86 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"programmingpercy.tech/websockets-go/hub"
+)
+
+// Messages is the repository of chat messages, each room numbers its messages from 1
+type Messages struct {
+	db *sql.DB
+}
+
+var _ hub.HistoryStore = (*Messages)(nil)
+
+// Append stores the message as the next message of room, creating the room if needed
+func (m *Messages) Append(room string, msg hub.NewMessageEvent) (hub.HistoryMessage, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return hub.HistoryMessage{}, err
+	}
+	defer tx.Rollback()
+
+	roomID, err := roomID(tx, room)
+	if err != nil {
+		return hub.HistoryMessage{}, err
+	}
+	var id int64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(id), 0) + 1 FROM messages WHERE room_id = ?`, roomID).Scan(&id); err != nil {
+		return hub.HistoryMessage{}, err
+	}
+	_, err = tx.Exec(`INSERT INTO messages (room_id, id, sender, body, sent_at) VALUES (?, ?, ?, ?, ?)`,
+		roomID, id, msg.From, msg.Message, msg.Sent.UnixNano())
+	if err != nil {
+		return hub.HistoryMessage{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return hub.HistoryMessage{}, err
+	}
+	return hub.HistoryMessage{ID: id, NewMessageEvent: msg}, nil
+}
+
+// Before returns up to limit messages of room with an ID lower than before, oldest first.
+// A before of 0 returns the newest messages
+func (m *Messages) Before(room string, before int64, limit int) ([]hub.HistoryMessage, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	if before <= 0 {
+		before = 1<<63 - 1
+	}
+	// Take the newest page in reverse and flip it, so the index on (room_id, id) is used
+	rows, err := m.db.Query(`SELECT messages.id, messages.sender, messages.body, messages.sent_at
+		FROM messages JOIN rooms ON rooms.id = messages.room_id
+		WHERE rooms.name = ? AND messages.id < ?
+		ORDER BY messages.id DESC LIMIT ?`, room, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanHistory(rows)
+}
+
+// scanHistory reads the rows of a page selected newest first, and returns them oldest first
+func scanHistory(rows *sql.Rows) ([]hub.HistoryMessage, error) {
+	defer rows.Close()
+
+	var messages []hub.HistoryMessage
+	for rows.Next() {
+		var msg hub.HistoryMessage
+		var sent int64
+		if err := rows.Scan(&msg.ID, &msg.From, &msg.Message, &sent); err != nil {
+			return nil, err
+		}
+		msg.Sent = time.Unix(0, sent)
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
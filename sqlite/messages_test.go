@@ -0,0 +1,137 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"programmingpercy.tech/websockets-go/hub"
+)
+
+func TestMessages_Paging(t *testing.T) {
+	db, path := openTestDB(t)
+	messages := db.Messages()
+
+	sent := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	for i := 1; i <= 10; i++ {
+		msg := hub.NewMessageEvent{SendMessageEvent: hub.SendMessageEvent{Message: fmt.Sprint(i), From: "percy"}, Sent: sent}
+		stored, err := messages.Append("general", msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.ID != int64(i) {
+			t.Fatalf("expected id %d, got %d", i, stored.ID)
+		}
+	}
+	if stored, err := messages.Append("games", hub.NewMessageEvent{}); err != nil || stored.ID != 1 {
+		t.Fatalf("every room should count from 1, got %d %v", stored.ID, err)
+	}
+	db.Close()
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	messages = db.Messages()
+
+	testCases := []struct {
+		before int64
+		limit  int
+		want   string
+	}{
+		{before: 0, limit: 3, want: "[8 9 10]"},
+		{before: 8, limit: 3, want: "[5 6 7]"},
+		{before: 3, limit: 3, want: "[1 2]"},
+		{before: 1, limit: 3, want: "[]"},
+	}
+	for _, tc := range testCases {
+		page, err := messages.Before("general", tc.before, tc.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []int64{}
+		for _, msg := range page {
+			ids = append(ids, msg.ID)
+		}
+		if got := fmt.Sprint(ids); got != tc.want {
+			t.Errorf("before %d: expected %s, got %s", tc.before, tc.want, got)
+		}
+	}
+
+	page, _ := messages.Before("general", 2, 1)
+	if len(page) != 1 || page[0].Message != "1" || page[0].From != "percy" || !page[0].Sent.Equal(sent) {
+		t.Errorf("message did not round trip %+v", page)
+	}
+}
+
+// TestManager_WithSQLite runs the hub on top of the repositories, the way main does
+func TestManager_WithSQLite(t *testing.T) {
+	db, _ := openTestDB(t)
+	if err := db.Users().AddUser("percy", "123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Rooms().SaveMembership("percy", "games"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager, err := hub.NewManager(ctx, db.Users(), hub.WithHistory(db.Messages(), 50), hub.WithRoomStore(db.Rooms()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", manager.LoginHandler)
+	mux.HandleFunc("/ws", manager.ServeWS)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// Login is checked against the users table
+	body, _ := json.Marshal(map[string]string{"username": "percy", "password": "123"})
+	resp, err := http.Post(srv.URL+"/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var login struct {
+		OTP string `json:"otp"`
+	}
+	json.NewDecoder(resp.Body).Decode(&login)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login failed with %d", resp.StatusCode)
+	}
+
+	header := http.Header{}
+	header.Set("Origin", "https://localhost:8080")
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?otp="+login.OTP, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// percy is put back in games, so the message lands in its history
+	conn.WriteJSON(hub.Event{Type: hub.EventSendMessage, Payload: hub.NewPayload(json.RawMessage(`{"message":"hello"}`))})
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	// The presence snapshot and history of games come first
+	var event hub.Event
+	for event.Type != hub.EventNewMessage {
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("expected new_message: %v", err)
+		}
+	}
+	page, err := db.Messages().Before("games", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].Message != "hello" || page[0].From != "percy" {
+		t.Errorf("expected the message to be stored in games, got %+v", page)
+	}
+}
@@ -0,0 +1,90 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations are applied in order, each one once. The version of a migration is its index + 1.
+// Never change a migration that has been released, add a new one instead
+var migrations = []string{
+	// 1: users, rooms, the room each user is in and the messages of each room
+	`CREATE TABLE users (
+		username      TEXT PRIMARY KEY,
+		password_hash TEXT NOT NULL,
+		roles         TEXT NOT NULL DEFAULT '',
+		created_at    INTEGER NOT NULL
+	);
+	CREATE TABLE rooms (
+		id         INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL UNIQUE,
+		created_at INTEGER NOT NULL
+	);
+	CREATE TABLE memberships (
+		username  TEXT PRIMARY KEY,
+		room_id   INTEGER NOT NULL REFERENCES rooms(id),
+		joined_at INTEGER NOT NULL
+	);
+	CREATE TABLE messages (
+		room_id INTEGER NOT NULL REFERENCES rooms(id),
+		id      INTEGER NOT NULL,
+		sender  TEXT NOT NULL,
+		body    TEXT NOT NULL,
+		sent_at INTEGER NOT NULL,
+		PRIMARY KEY (room_id, id)
+	);`,
+	// 2: direct messages between two users and who blocked whom
+	`CREATE TABLE direct_messages (
+		conversation TEXT NOT NULL,
+		id           INTEGER NOT NULL,
+		sender       TEXT NOT NULL,
+		body         TEXT NOT NULL,
+		sent_at      INTEGER NOT NULL,
+		PRIMARY KEY (conversation, id)
+	);
+	CREATE TABLE blocks (
+		username   TEXT NOT NULL,
+		blocked    TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (username, blocked)
+	);`,
+}
+
+// migrate brings the schema up to the latest version, every migration runs in its own transaction
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this binary supports (%d)", current, len(migrations))
+	}
+
+	for version := current + 1; version <= len(migrations); version++ {
+		if err := applyMigration(db, version); err != nil {
+			return fmt.Errorf("migration %d failed: %w", version, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs a single migration and records its version
+func applyMigration(db *sql.DB, version int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(migrations[version-1]); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
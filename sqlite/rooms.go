@@ -0,0 +1,96 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"programmingpercy.tech/websockets-go/hub"
+)
+
+// Rooms is the repository of rooms and the room each user is in
+type Rooms struct {
+	db *sql.DB
+}
+
+var _ hub.RoomStore = (*Rooms)(nil)
+
+// roomID returns the id of the room called name, creating the room if it does not exist
+func roomID(tx *sql.Tx, name string) (int64, error) {
+	_, err := tx.Exec(`INSERT INTO rooms (name, created_at) VALUES (?, ?) ON CONFLICT (name) DO NOTHING`, name, time.Now().UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = tx.QueryRow(`SELECT id FROM rooms WHERE name = ?`, name).Scan(&id)
+	return id, err
+}
+
+// SaveMembership records that username is in room
+func (r *Rooms) SaveMembership(username, room string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := roomID(tx, room)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO memberships (username, room_id, joined_at) VALUES (?, ?, ?)
+		ON CONFLICT (username) DO UPDATE SET room_id = excluded.room_id, joined_at = excluded.joined_at`,
+		username, id, time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Membership returns the room username was last in
+func (r *Rooms) Membership(username string) (string, bool, error) {
+	var room string
+	err := r.db.QueryRow(`SELECT rooms.name FROM memberships JOIN rooms ON rooms.id = memberships.room_id
+		WHERE memberships.username = ?`, username).Scan(&room)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return room, true, nil
+}
+
+// Members returns the users whose last room is room, sorted
+func (r *Rooms) Members(room string) ([]string, error) {
+	rows, err := r.db.Query(`SELECT memberships.username FROM memberships JOIN rooms ON rooms.id = memberships.room_id
+		WHERE rooms.name = ? ORDER BY memberships.username`, room)
+	if err != nil {
+		return nil, err
+	}
+	return scanStrings(rows)
+}
+
+// Rooms returns the names of all rooms, sorted
+func (r *Rooms) Rooms() ([]string, error) {
+	rows, err := r.db.Query(`SELECT name FROM rooms ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	return scanStrings(rows)
+}
+
+// scanStrings reads a single text column from every row and closes rows
+func scanStrings(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,47 @@
+package sqlite
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRooms_Memberships(t *testing.T) {
+	db, path := openTestDB(t)
+	rooms := db.Rooms()
+
+	if _, ok, err := rooms.Membership("percy"); ok || err != nil {
+		t.Fatalf("expected no membership before joining, got %v %v", ok, err)
+	}
+	for _, join := range [][2]string{{"percy", "games"}, {"anna", "games"}, {"percy", "music"}} {
+		if err := rooms.SaveMembership(join[0], join[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	// Everything should survive a restart
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rooms = db.Rooms()
+
+	if room, ok, err := rooms.Membership("percy"); room != "music" || !ok || err != nil {
+		t.Errorf("expected percy in music, got %q %v %v", room, ok, err)
+	}
+	names, err := rooms.Rooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(names); got != "[games music]" {
+		t.Errorf("unexpected rooms %s", got)
+	}
+	members, err := rooms.Members("games")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(members); got != "[anna]" {
+		t.Errorf("expected only anna left in games, got %s", got)
+	}
+}
@@ -0,0 +1,84 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"programmingpercy.tech/websockets-go/hub"
+)
+
+// dummyHash is compared against when a user does not exist, so a missing user takes as long as a wrong password.
+// It is hashed the first time it is needed, not when the package is loaded
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic("failed to hash the dummy password: " + err.Error())
+	}
+	return hash
+})
+
+// Users is the repository of user accounts, passwords are stored as bcrypt hashes
+type Users struct {
+	db *sql.DB
+}
+
+var (
+	_ hub.Authenticator = (*Users)(nil)
+	_ hub.UserDirectory = (*Users)(nil)
+)
+
+// AddUser creates the user or replaces its password and roles
+func (u *Users) AddUser(username, password string, roles ...string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = u.db.Exec(`INSERT INTO users (username, password_hash, roles, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (username) DO UPDATE SET password_hash = excluded.password_hash, roles = excluded.roles`,
+		username, string(hash), strings.Join(roles, ","), time.Now().UnixNano())
+	return err
+}
+
+// RemoveUser deletes the user, it is not an error if it does not exist
+func (u *Users) RemoveUser(username string) error {
+	_, err := u.db.Exec(`DELETE FROM users WHERE username = ?`, username)
+	return err
+}
+
+// Authenticate verifies the password of username, it makes Users a hub.Authenticator
+func (u *Users) Authenticate(username, password string) (hub.Identity, error) {
+	var hash, roles string
+	err := u.db.QueryRow(`SELECT password_hash, roles FROM users WHERE username = ?`, username).Scan(&hash, &roles)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return hub.Identity{}, hub.ErrInvalidCredentials
+	}
+	if err != nil {
+		return hub.Identity{}, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return hub.Identity{}, hub.ErrInvalidCredentials
+	}
+	if err != nil {
+		return hub.Identity{}, err
+	}
+
+	identity := hub.Identity{Username: username}
+	if roles != "" {
+		identity.Roles = strings.Split(roles, ",")
+	}
+	return identity, nil
+}
+
+// UserExists reports if username has an account, it makes Users a hub.UserDirectory
+func (u *Users) UserExists(username string) (bool, error) {
+	var exists bool
+	err := u.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE username = ?)`, username).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,57 @@
+package sqlite
+
+import (
+	"errors"
+	"testing"
+
+	"programmingpercy.tech/websockets-go/hub"
+)
+
+func TestUsers_Authenticate(t *testing.T) {
+	db, _ := openTestDB(t)
+	users := db.Users()
+	if err := users.AddUser("percy", "123", "admin", "moderator"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.AddUser("anna", "123"); err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := users.Authenticate("percy", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Username != "percy" || !identity.HasRole("admin") || !identity.HasRole("moderator") {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if identity, _ := users.Authenticate("anna", "123"); len(identity.Roles) != 0 {
+		t.Errorf("anna should have no roles, got %v", identity.Roles)
+	}
+
+	if _, err := users.Authenticate("percy", "wrong"); !errors.Is(err, hub.ErrInvalidCredentials) {
+		t.Errorf("expected invalid credentials for a wrong password, got %v", err)
+	}
+	if _, err := users.Authenticate("nobody", "123"); !errors.Is(err, hub.ErrInvalidCredentials) {
+		t.Errorf("expected invalid credentials for a unknown user, got %v", err)
+	}
+
+	// Adding again replaces the password
+	if err := users.AddUser("percy", "456"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Authenticate("percy", "123"); !errors.Is(err, hub.ErrInvalidCredentials) {
+		t.Errorf("the old password should stop working, got %v", err)
+	}
+	if err := users.RemoveUser("anna"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Authenticate("anna", "123"); !errors.Is(err, hub.ErrInvalidCredentials) {
+		t.Errorf("removed users should not login, got %v", err)
+	}
+	if exists, err := users.UserExists("percy"); !exists || err != nil {
+		t.Errorf("expected percy to exist, got %v %v", exists, err)
+	}
+	if exists, _ := users.UserExists("anna"); exists {
+		t.Error("removed users should not exist")
+	}
+}